@@ -0,0 +1,257 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// errUnsupportedValue is returned by codecs for values they
+// have no representation for, such as a poll's match rules
+// in CSV.
+var errUnsupportedValue = errors.New("value cannot be represented in the media type")
+
+// Codec encodes response bodies in a media type.
+type Codec interface {
+	// Encode writes v to w, returning errUnsupportedValue
+	// if the media type cannot represent it.
+	Encode(w io.Writer, v interface{}) error
+}
+
+// BodyDecoder is implemented by the codecs that also read
+// request bodies. Requests whose bodies are in a media type
+// without a BodyDecoder are rejected with 415 Unsupported
+// Media Type.
+type BodyDecoder interface {
+	Decode(r io.Reader, v interface{}) error
+}
+
+// mediaCodec is a codec along with the media type it is
+// registered for.
+type mediaCodec struct {
+	mediaType string
+	codec     Codec
+}
+
+// RegisterCodec makes the API serve bodies in the media type,
+// encoded by the codec, to the clients accepting it. It
+// replaces the codec already registered for the media type,
+// if any. When clients accept anything, the codec registered
+// first, for JSON, is used. Codecs must be registered before
+// the API is served.
+func (s *Server) RegisterCodec(mediaType string, c Codec) {
+	for _, mc := range s.codecs {
+		if mc.mediaType == mediaType {
+			mc.codec = c
+			return
+		}
+	}
+	s.codecs = append(s.codecs, &mediaCodec{mediaType: mediaType, codec: c})
+}
+
+// registerDefaultCodecs registers the codecs the API
+// comes with.
+func (s *Server) registerDefaultCodecs() {
+	s.RegisterCodec("application/json", jsonCodec{})
+	s.RegisterCodec("text/csv", csvCodec{})
+	s.RegisterCodec("application/xml", xmlCodec{})
+	s.RegisterCodec("text/xml", xmlCodec{})
+	s.RegisterCodec("application/msgpack", msgpackCodec{})
+	s.RegisterCodec("application/x-msgpack", msgpackCodec{})
+}
+
+// mediaTypes lists the registered media types.
+func (s *Server) mediaTypes() []string {
+	types := make([]string, len(s.codecs))
+	for i, mc := range s.codecs {
+		types[i] = mc.mediaType
+	}
+	return types
+}
+
+// negotiated holds the codecs chosen for a request.
+type negotiated struct {
+	// response is the codec responses are encoded with,
+	// or nil if the client accepts none of them.
+	response *mediaCodec
+	// request decodes the body of the request.
+	request BodyDecoder
+	// mediaTypes lists the media types the API offers.
+	mediaTypes []string
+}
+
+var contextKeyCodecs = &contextKey{"codecs"}
+
+// maxBodySize bounds the size of request bodies; polls and
+// keys take a few kilobytes at most.
+const maxBodySize = 1 << 20
+
+// withCodecs is a wrapper of a HandlerFunc choosing the codec
+// of the response from the Accept header, and that of the body
+// from the Content-Type header, defaulting to JSON for both.
+// Requests with a body in a media type the API cannot read get
+// 415 Unsupported Media Type; clients accepting none of the
+// media types get 406 Not Acceptable, once there is a body to
+// send them. Bodies are cut off after maxBodySize bytes.
+func (s *Server) withCodecs(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+		n := &negotiated{
+			response:   s.acceptedCodec(r.Header.Get("Accept")),
+			request:    jsonCodec{},
+			mediaTypes: s.mediaTypes(),
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "" && r.ContentLength != 0 {
+			n.request = s.bodyDecoder(ct)
+			if n.request == nil {
+				ctx := context.WithValue(r.Context(), contextKeyCodecs, n)
+				respondErrCode(w, r.WithContext(ctx), http.StatusUnsupportedMediaType, "unsupported_media_type",
+					"cannot read ", ct, " bodies; send one of ", strings.Join(s.decodableTypes(), ", "))
+				return
+			}
+		}
+		w.Header().Add("Vary", "Accept")
+		ctx := context.WithValue(r.Context(), contextKeyCodecs, n)
+		fn(w, r.WithContext(ctx))
+	}
+}
+
+// bodyDecoder returns the decoder of the content type, or nil
+// if no codec of the content type reads bodies.
+func (s *Server) bodyDecoder(contentType string) BodyDecoder {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+	for _, mc := range s.codecs {
+		if mc.mediaType == mediaType {
+			d, _ := mc.codec.(BodyDecoder)
+			return d
+		}
+	}
+	return nil
+}
+
+// decodableTypes lists the media types of request bodies.
+func (s *Server) decodableTypes() []string {
+	var types []string
+	for _, mc := range s.codecs {
+		if _, ok := mc.codec.(BodyDecoder); ok {
+			types = append(types, mc.mediaType)
+		}
+	}
+	return types
+}
+
+// mediaRange is a media range of an Accept header.
+type mediaRange struct {
+	mediaType string // such as text/csv, text/* or */*
+	q         float64
+	index     int // position in the header
+}
+
+// matches reports whether the range includes the media type,
+// and how specifically: 3 for an exact match, 2 for type/*,
+// 1 for */* and 0 for no match.
+func (mr *mediaRange) matches(mediaType string) int {
+	switch {
+	case mr.mediaType == mediaType:
+		return 3
+	case mr.mediaType == "*/*":
+		return 1
+	case strings.HasSuffix(mr.mediaType, "/*") &&
+		strings.HasPrefix(mediaType, strings.TrimSuffix(mr.mediaType, "*")):
+		return 2
+	}
+	return 0
+}
+
+// parseAccept returns the media ranges of an Accept header.
+// Malformed ranges are ignored.
+func parseAccept(accept string) []*mediaRange {
+	var ranges []*mediaRange
+	for i, part := range strings.Split(accept, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, &mediaRange{mediaType: mediaType, q: q, index: i})
+	}
+	return ranges
+}
+
+// acceptedCodec returns the codec the client prefers, as told
+// by the Accept header, or nil if it accepts none of them.
+// Every codec is weighted by the most specific range including
+// its media type; ties go to the range listed first, then to
+// the codec registered first.
+func (s *Server) acceptedCodec(accept string) *mediaCodec {
+	if strings.TrimSpace(accept) == "" {
+		return s.codecs[0]
+	}
+	ranges := parseAccept(accept)
+	type candidate struct {
+		codec *mediaCodec
+		q     float64
+		index int
+	}
+	var candidates []candidate
+	for _, mc := range s.codecs {
+		var best *mediaRange
+		specificity := 0
+		for _, mr := range ranges {
+			if m := mr.matches(mc.mediaType); m > specificity {
+				best, specificity = mr, m
+			}
+		}
+		if best != nil && best.q > 0 {
+			candidates = append(candidates, candidate{mc, best.q, best.index})
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].q != candidates[j].q {
+			return candidates[i].q > candidates[j].q
+		}
+		return candidates[i].index < candidates[j].index
+	})
+	return candidates[0].codec
+}
+
+// requestCodecs returns the codecs chosen for the request,
+// defaulting to JSON.
+func requestCodecs(r *http.Request) *negotiated {
+	if n, ok := r.Context().Value(contextKeyCodecs).(*negotiated); ok {
+		return n
+	}
+	mc := &mediaCodec{mediaType: "application/json", codec: jsonCodec{}}
+	return &negotiated{response: mc, request: jsonCodec{}, mediaTypes: []string{mc.mediaType}}
+}
+
+// jsonCodec encodes and decodes JSON.
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func (jsonCodec) Decode(r io.Reader, v interface{}) error {
+	return json.NewDecoder(r).Decode(v)
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"socialpoll/apikey"
+	"socialpoll/poll"
+	"strings"
+	"testing"
+)
+
+// createTestPoll creates an open poll of the key's owner,
+// returning its path.
+func (a *testAPI) createTestPoll(t *testing.T, key, title string, options ...string) string {
+	body := `{"title":"` + title + `","options":["` + strings.Join(options, `","`) + `"],"status":"open"}`
+	w := a.do("POST", "/v1/polls", key, body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("failed to create poll: %d %s", w.Code, w.Body)
+	}
+	return w.Header().Get("Location")
+}
+
+func TestNegotiation(t *testing.T) {
+	a := newTestAPI(t)
+	key := a.mint(t, "alice", apikey.ScopePolls)
+	path := a.createTestPoll(t, key, "Editors", "vim", "emacs")
+	tests := []struct {
+		accept      string
+		status      int
+		contentType string
+	}{
+		{"", http.StatusOK, "application/json"},
+		{"*/*", http.StatusOK, "application/json"},
+		{"text/csv", http.StatusOK, "text/csv; charset=utf-8"},
+		{"application/xml;q=0.5, application/msgpack", http.StatusOK, "application/msgpack"},
+		{"application/msgpack;q=0.1, text/xml", http.StatusOK, "text/xml; charset=utf-8"},
+		{"text/*", http.StatusOK, "text/csv; charset=utf-8"},
+		{"image/png", http.StatusNotAcceptable, "application/json"},
+		{"application/json;q=0", http.StatusNotAcceptable, "application/json"},
+	}
+	for _, test := range tests {
+		w := a.do("GET", path, key, "", "Accept", test.accept)
+		if w.Code != test.status {
+			t.Errorf("Accept %q: expected %d, got %d %s", test.accept, test.status, w.Code, w.Body)
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); ct != test.contentType {
+			t.Errorf("Accept %q: expected %s, got %s", test.accept, test.contentType, ct)
+		}
+		// ETags belong to the poll, only sent along with it
+		if etag := w.Header().Get("ETag"); (etag != "") != (test.status == http.StatusOK) {
+			t.Errorf("Accept %q: unexpected ETag %q with status %d", test.accept, etag, w.Code)
+		}
+		if test.status == http.StatusNotAcceptable {
+			if code := errorCodeOf(t, w); code != "not_acceptable" {
+				t.Errorf("Accept %q: expected not_acceptable, got %s", test.accept, code)
+			}
+		}
+	}
+}
+
+func TestNegotiationRejectsUnrepresentableValues(t *testing.T) {
+	a := newTestAPI(t)
+	admin := a.mint(t, "root", apikey.ScopeAdmin)
+	w := a.do("GET", "/v1/keys", admin, "", "Accept", "text/csv")
+	if w.Code != http.StatusNotAcceptable {
+		t.Fatalf("expected 406 for keys in CSV, got %d %s", w.Code, w.Body)
+	}
+	w = a.do("GET", "/v1/keys", admin, "", "Accept", "text/csv, application/json;q=0.5")
+	if w.Code != http.StatusNotAcceptable {
+		t.Errorf("expected 406 since the preferred media type cannot hold keys, got %d", w.Code)
+	}
+}
+
+func TestUnsupportedMediaType(t *testing.T) {
+	a := newTestAPI(t)
+	key := a.mint(t, "alice", apikey.ScopePolls)
+	// XML and CSV are only sent, never read
+	for _, ct := range []string{"application/x-www-form-urlencoded", "text/csv", "application/xml", "text/xml",
+		"text/plain", "not a media type"} {
+		w := a.do("POST", "/v1/polls", key, "title=Editors", "Content-Type", ct)
+		if w.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("%s: expected 415, got %d %s", ct, w.Code, w.Body)
+			continue
+		}
+		if code := errorCodeOf(t, w); code != "unsupported_media_type" {
+			t.Errorf("%s: expected unsupported_media_type, got %s", ct, code)
+		}
+	}
+	if polls, _ := a.polls.List(poll.Query{}); len(polls) != 0 {
+		t.Errorf("expected no poll to be created, got %d", len(polls))
+	}
+}
+
+func TestDecodesMsgpackBodies(t *testing.T) {
+	a := newTestAPI(t)
+	key := a.mint(t, "alice", apikey.ScopePolls)
+	var buf bytes.Buffer
+	err := (msgpackCodec{}).Encode(&buf, map[string]interface{}{
+		"title":   "Editors",
+		"options": []interface{}{"vim", "emacs"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := a.do("POST", "/v1/polls", key, buf.String(), "Content-Type", "application/msgpack")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 for a msgpack body, got %d %s", w.Code, w.Body)
+	}
+	w = a.do("GET", w.Header().Get("Location"), key, "")
+	if !strings.Contains(w.Body.String(), `"options":["vim","emacs"]`) {
+		t.Errorf("poll not read from msgpack: %s", w.Body)
+	}
+}
+
+func TestCSVPolls(t *testing.T) {
+	a := newTestAPI(t)
+	key := a.mint(t, "alice", apikey.ScopePolls)
+	path := a.createTestPoll(t, key, "Editors", "vim", "emacs")
+	id := path[strings.LastIndex(path, "/")+1:]
+
+	w := a.do("GET", path, key, "", "Accept", "text/csv")
+	if want := "option,count\nvim,0\nemacs,0\n"; w.Body.String() != want {
+		t.Errorf("expected %q for a poll, got %q", want, w.Body)
+	}
+	// a list holding a single poll keeps the poll column
+	w = a.do("GET", "/v1/polls", key, "", "Accept", "text/csv")
+	if want := "poll,option,count\n" + id + ",vim,0\n" + id + ",emacs,0\n"; w.Body.String() != want {
+		t.Errorf("expected %q for a list of one poll, got %q", want, w.Body)
+	}
+	a.createTestPoll(t, key, "Shells", "bash")
+	w = a.do("GET", "/v1/polls", key, "", "Accept", "text/csv")
+	if lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n"); len(lines) != 4 || lines[0] != "poll,option,count" {
+		t.Errorf("unexpected list of two polls %q", w.Body)
+	}
+	// an empty list still has its header
+	other := a.mint(t, "bob", apikey.ScopePolls)
+	w = a.do("GET", "/v1/polls", other, "", "Accept", "text/csv")
+	if want := "poll,option,count\n"; w.Body.String() != want {
+		t.Errorf("expected %q for no polls, got %q", want, w.Body)
+	}
+}
+
+func TestRequestBodyTooLarge(t *testing.T) {
+	a := newTestAPI(t)
+	key := a.mint(t, "alice", apikey.ScopePolls)
+	path := a.createTestPoll(t, key, "Editors", "vim")
+	title := strings.Repeat("a", maxBodySize)
+	tests := []struct {
+		method, path, contentType string
+	}{
+		{"POST", "/v1/polls", "application/json"},
+		{"POST", "/v1/polls", "application/msgpack"},
+		{"PATCH", path, "application/json"},
+	}
+	for _, test := range tests {
+		body := `{"title":"` + title + `","options":["vim"]}`
+		if test.contentType == "application/msgpack" {
+			var buf bytes.Buffer
+			(msgpackCodec{}).Encode(&buf, map[string]interface{}{"title": title, "options": []interface{}{"vim"}})
+			body = buf.String()
+		}
+		w := a.do(test.method, test.path, key, body, "Content-Type", test.contentType, "If-Match", "*")
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s %s in %s: expected 413, got %d", test.method, test.path, test.contentType, w.Code)
+		}
+	}
+}
+
+func TestDeeplyNestedMsgpackBody(t *testing.T) {
+	a := newTestAPI(t)
+	key := a.mint(t, "alice", apikey.ScopePolls)
+	body := string(bytes.Repeat([]byte{0x91}, maxBodySize))
+	w := a.do("POST", "/v1/polls", key, body, "Content-Type", "application/msgpack")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS is the policy letting websites hosted on other origins
+// call the API, circumnavigating the same-origin policy.
+type CORS struct {
+	// Origins lists the origins allowed: exact origins such as
+	// https://example.com, every subdomain of one as in
+	// https://*.example.com, or * for any origin.
+	Origins []string
+	// Credentials lets the allowed origins send cookies and
+	// credentials along. Origins are then echoed rather than
+	// allowed with *, and * allows no origin at all, as any
+	// website could otherwise act for the users visiting it.
+	Credentials bool
+	// MaxAge is how long browsers may remember the answer to a
+	// preflight request; zero leaves it to the browser.
+	MaxAge time.Duration
+}
+
+// corsAllowHeaders are the request headers websites may send,
+// besides those browsers always allow: keys may be sent as
+// credentials, edits carry If-Match and a body in another
+// media type than forms, and streams resume from an event.
+const corsAllowHeaders = "Authorization, Content-Type, If-Match, Last-Event-ID"
+
+// corsExposeHeaders are the response headers websites may read,
+// besides those browsers always expose.
+const corsExposeHeaders = "Location, ETag, Link, Retry-After, " +
+	"RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset"
+
+// SetCORS sets the policy for calls from other origins. By
+// default any origin may call the API, without credentials.
+// The policy must be set before the API is served.
+func (s *Server) SetCORS(c CORS) {
+	s.cors = c
+}
+
+// allowedOrigin returns the value of Access-Control-Allow-Origin
+// for the origin, or "" if it may not call the API.
+func (c *CORS) allowedOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	for _, allowed := range c.Origins {
+		if allowed == "*" {
+			if c.Credentials {
+				continue
+			}
+			return "*"
+		}
+		if matchOrigin(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// matchOrigin reports whether origin is the allowed one or,
+// if the host of the allowed one starts with *., one of the
+// subdomains of the rest, at any depth. Origins are compared
+// ignoring case.
+func matchOrigin(allowed, origin string) bool {
+	allowed, origin = strings.ToLower(allowed), strings.ToLower(origin)
+	i := strings.Index(allowed, "://*.")
+	if i < 0 {
+		return allowed == origin
+	}
+	prefix, suffix := allowed[:i+len("://")], allowed[i+len("://*"):]
+	if !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) ||
+		len(origin) <= len(prefix)+len(suffix) {
+		return false
+	}
+	sub := origin[len(prefix) : len(origin)-len(suffix)]
+	return !strings.ContainsAny(sub, "/:@")
+}
+
+// withCORS is a wrapper of a HandlerFunc applying the CORS
+// policy. Calls from allowed origins may read the response
+// and the headers of corsExposeHeaders. Preflight requests,
+// asking the browser's permission to send a call, are answered
+// here for the routes of rt, with the methods of the route and
+// the headers of corsAllowHeaders, and never reach fn. Origins
+// that are not allowed get no CORS headers at all, which makes
+// browsers refuse them.
+func (s *Server) withCORS(rt *Router, fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		origin := s.cors.allowedOrigin(r.Header.Get("Origin"))
+		if origin == "" {
+			fn(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if s.cors.Credentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		if r.Method != "OPTIONS" || r.Header.Get("Access-Control-Request-Method") == "" {
+			w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+			fn(w, r)
+			return
+		}
+		methods := rt.Methods(routePath(r.URL.EscapedPath()))
+		if methods == nil {
+			fn(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+		if s.cors.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(s.cors.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// routePath returns the path the router sees for the path
+// of a request, served under /v1/ or without the prefix.
+func routePath(p string) string {
+	if strings.HasPrefix(p, "/v1/") {
+		return p[len("/v1"):]
+	}
+	return p
+}
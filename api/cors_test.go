@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+	"socialpoll/apikey"
+	"testing"
+)
+
+func TestCORSAllowedOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		cors    CORS
+		origin  string
+		allowed string
+	}{
+		{"any", CORS{Origins: []string{"*"}}, "https://evil.example", "*"},
+		{"no origin", CORS{Origins: []string{"*"}}, "", ""},
+		{"exact", CORS{Origins: []string{"https://example.com"}}, "https://example.com", "https://example.com"},
+		{"exact ignoring case", CORS{Origins: []string{"https://Example.com"}}, "https://example.COM", "https://example.COM"},
+		{"other scheme", CORS{Origins: []string{"https://example.com"}}, "http://example.com", ""},
+		{"other port", CORS{Origins: []string{"https://example.com"}}, "https://example.com:8443", ""},
+		{"subdomain", CORS{Origins: []string{"https://*.example.com"}}, "https://a.b.example.com", "https://a.b.example.com"},
+		{"not a subdomain", CORS{Origins: []string{"https://*.example.com"}}, "https://example.com", ""},
+		{"suffix only", CORS{Origins: []string{"https://*.example.com"}}, "https://evilexample.com", ""},
+		{"credentials", CORS{Origins: []string{"https://example.com"}, Credentials: true},
+			"https://example.com", "https://example.com"},
+		{"credentials with any", CORS{Origins: []string{"*"}, Credentials: true}, "https://evil.example", ""},
+		{"credentials with any and explicit", CORS{Origins: []string{"*", "https://example.com"}, Credentials: true},
+			"https://example.com", "https://example.com"},
+		{"credentials with any and other", CORS{Origins: []string{"*", "https://example.com"}, Credentials: true},
+			"https://evil.example", ""},
+	}
+	for _, test := range tests {
+		if got := test.cors.allowedOrigin(test.origin); got != test.allowed {
+			t.Errorf("%s: expected %q for %q, got %q", test.name, test.allowed, test.origin, got)
+		}
+	}
+}
+
+func TestCORSCredentialsWithAnyOrigin(t *testing.T) {
+	a := newTestAPI(t)
+	a.SetCORS(CORS{Origins: []string{"*"}, Credentials: true})
+	a.handler = a.Handler()
+	key := a.mint(t, "alice", apikey.ScopePolls)
+	for _, method := range []string{"GET", "OPTIONS"} {
+		w := a.do(method, "/v1/polls", key, "", "Origin", "https://evil.example", "Access-Control-Request-Method", "GET")
+		for _, h := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Credentials", "Access-Control-Allow-Methods"} {
+			if v := w.Header().Get(h); v != "" {
+				t.Errorf("%s: expected no %s, got %q", method, h, v)
+			}
+		}
+	}
+	a.SetCORS(CORS{Origins: []string{"https://example.com"}, Credentials: true})
+	a.handler = a.Handler()
+	w := a.do("GET", "/v1/polls", key, "", "Origin", "https://example.com")
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "https://example.com" ||
+		w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("expected the listed origin to be allowed with credentials, got %v", w.Header())
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/csv"
+	"io"
+	"socialpoll/poll"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// csvCodec encodes votes as CSV, for spreadsheets: polls,
+// results and options as option,count rows, and timelines
+// as a time column followed by a column per option. Lists of
+// polls, however many they hold, get a leading poll column.
+type csvCodec struct{}
+
+func (csvCodec) Encode(w io.Writer, v interface{}) error {
+	var rows [][]string
+	switch v := v.(type) {
+	case onePoll:
+		rows = pollRows(v[0])
+	case []*poll.Poll:
+		rows = [][]string{{"poll", "option", "count"}}
+		for _, p := range v {
+			for _, row := range pollRows(p)[1:] {
+				rows = append(rows, append([]string{p.ID.Hex()}, row...))
+			}
+		}
+	case *poll.Poll:
+		rows = pollRows(v)
+	case *pollResults:
+		rows = [][]string{{"option", "count"}}
+		options := make([]string, 0, len(v.Results))
+		for o := range v.Results {
+			options = append(options, o)
+		}
+		sort.Strings(options)
+		for _, o := range options {
+			rows = append(rows, []string{o, strconv.Itoa(v.Results[o])})
+		}
+	case *option:
+		rows = [][]string{{"option", "count"}, {v.Option, strconv.Itoa(v.Votes)}}
+	case []*option:
+		rows = [][]string{{"option", "count"}}
+		for _, o := range v {
+			rows = append(rows, []string{o.Option, strconv.Itoa(o.Votes)})
+		}
+	case *timeline:
+		rows = timelineRows(v)
+	default:
+		return errUnsupportedValue
+	}
+	cw := csv.NewWriter(w)
+	cw.WriteAll(rows)
+	return cw.Error()
+}
+
+// pollRows returns the option,count rows of the poll,
+// in the order of its options.
+func pollRows(p *poll.Poll) [][]string {
+	rows := [][]string{{"option", "count"}}
+	for _, o := range p.Options {
+		rows = append(rows, []string{o, strconv.Itoa(p.Results[o])})
+	}
+	return rows
+}
+
+// timelineRows returns a row per bucket of the timeline.
+func timelineRows(t *timeline) [][]string {
+	options := make([]string, 0, len(t.Series))
+	for o := range t.Series {
+		options = append(options, o)
+	}
+	sort.Strings(options)
+	rows := [][]string{append([]string{"time"}, options...)}
+	for i, at := range t.Times {
+		row := []string{at.Format(time.RFC3339)}
+		for _, o := range options {
+			row = append(row, strconv.Itoa(t.Series[o][i]))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"socialpoll/poll"
+	"strconv"
+	"strings"
+)
+
+// etag returns the entity tag of a poll, naming its version.
+// Responses suffix it with a digest of the body sent, as the
+// votes change without bumping the version.
+func etag(p *poll.Poll) string {
+	return `"` + strconv.Itoa(p.Version) + `"`
+}
+
+// tagVersion returns the version an entity tag given by etag,
+// suffixed or not, names. Weak tags name none.
+func tagVersion(tag string) (int, bool) {
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+	tag = tag[1 : len(tag)-1]
+	if i := strings.Index(tag, "-"); i >= 0 {
+		tag = tag[:i]
+	}
+	n, err := strconv.Atoi(tag)
+	return n, err == nil
+}
+
+// expectedVersion returns the version of the poll the client
+// edited, read from the If-Match header or else from the version
+// in the body, reporting whether it found one.
+// If-Match compares versions only, so the tags of any response
+// carrying the poll match, whatever the votes it held; * matches
+// the current version, and tags naming no version match none.
+func expectedVersion(r *http.Request, body []byte, current *poll.Poll) (int, bool) {
+	if match := r.Header.Get("If-Match"); match != "" {
+		version := -1
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" {
+				return current.Version, true
+			}
+			if n, ok := tagVersion(tag); ok {
+				if n == current.Version {
+					return n, true
+				}
+				version = n
+			}
+		}
+		return version, true
+	}
+	var v struct {
+		Version *int `json:"version"`
+	}
+	if err := unmarshalBody(r, body, &v); err != nil || v.Version == nil {
+		return 0, false
+	}
+	return *v.Version, true
+}
+
+// handlePollEdit serves PUT /polls/{id}, replacing the poll, and
+// PATCH /polls/{id}, changing only the fields in the body. Either
+// must name the version edited, with If-Match or a version field.
+func (s *Server) handlePollEdit(w http.ResponseWriter, r *http.Request) {
+	id := Param(r, "id")
+	current, ok := s.ownedPoll(w, r, id)
+	if !ok {
+		return
+	}
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		respondBodyErr(w, r, "poll", err)
+		return
+	}
+	version, ok := expectedVersion(r, body, current)
+	if !ok {
+		respondErrCode(w, r, http.StatusPreconditionRequired, "version_required",
+			"give the version edited with If-Match or a version field")
+		return
+	}
+	if version != current.Version {
+		respondErrCode(w, r, http.StatusPreconditionFailed, "version_mismatch",
+			"poll has changed, fetch it again")
+		return
+	}
+
+	var next poll.Poll
+	if r.Method == "PATCH" {
+		// start from a copy of the current poll
+		b, err := json.Marshal(current)
+		if err != nil {
+			respondErr(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if err := json.Unmarshal(b, &next); err != nil {
+			respondErr(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if err := unmarshalBody(r, body, &next); err != nil {
+		respondBodyErr(w, r, "poll", err)
+		return
+	}
+	if err := current.Edit(&next); err != nil {
+		if err == poll.ErrArchived {
+			respondErrCode(w, r, http.StatusConflict, "archived", err)
+			return
+		}
+		respondInvalid(w, r, err)
+		return
+	}
+	key, _ := APIKey(r.Context())
+	if !s.withinQuota(w, r, key, current.Owner, 0, len(next.Options)-len(current.Options)) {
+		return
+	}
+	if err := s.polls.Update(&next, version); err != nil {
+		switch err {
+		case poll.ErrNotFound:
+			respondHTTPErr(w, r, http.StatusNotFound)
+		case poll.ErrConflict:
+			respondErrCode(w, r, http.StatusPreconditionFailed, "version_mismatch",
+				"poll has changed, fetch it again")
+		default:
+			respondErr(w, r, http.StatusInternalServerError, "failed to update poll", err)
+		}
+		return
+	}
+	// the results as stored, with removed options archived
+	updated, err := s.polls.Get(id)
+	if err != nil {
+		respondErr(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	respondTagged(w, r, http.StatusOK, etag(updated), updated)
+}
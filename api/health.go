@@ -0,0 +1,123 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// checkTimeout is how long a dependency may take to answer
+// a health check before it is reported down.
+const checkTimeout = 2 * time.Second
+
+var errCheckTimeout = errors.New("timed out")
+
+// Check reports whether a dependency of the API works,
+// returning an error when it does not.
+type Check func() error
+
+// AddCheck makes the health endpoints check the named
+// dependency. Checks must be added before the API is served.
+func (s *Server) AddCheck(name string, check Check) {
+	if s.checks == nil {
+		s.checks = make(map[string]Check)
+	}
+	s.checks[name] = check
+}
+
+// health is the status of the API and its dependencies.
+type health struct {
+	Status string                  `json:"status"`
+	Checks map[string]*checkResult `json:"checks"`
+}
+
+// checkResult is the status of a dependency.
+type checkResult struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+	Error   string `json:"error,omitempty"`
+}
+
+// runChecks runs the checks concurrently, reporting whether
+// they all passed.
+func (s *Server) runChecks() (map[string]*checkResult, bool) {
+	results := make(map[string]*checkResult, len(s.checks))
+	var lock sync.Mutex // protects results
+	var wg sync.WaitGroup
+	for name, check := range s.checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			result := runCheck(check)
+			lock.Lock()
+			results[name] = result
+			lock.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+	ok := true
+	for _, result := range results {
+		if result.Error != "" {
+			ok = false
+		}
+	}
+	return results, ok
+}
+
+// runCheck runs the check, giving up after checkTimeout.
+func runCheck(check Check) *checkResult {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check()
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(checkTimeout):
+		err = errCheckTimeout
+	}
+	result := &checkResult{Status: "ok", Latency: time.Since(start).String()}
+	if err != nil {
+		result.Status = "down"
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// handleHealthz serves GET /healthz, responding with
+// 200 OK if every dependency works, and 503 Service
+// Unavailable otherwise.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	respondHealth(w, r, s.checkHealth())
+}
+
+// handleReadyz serves GET /readyz, which also fails once the
+// server is shutting down, so that load balancers stop sending
+// it requests.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if s.shuttingDown() {
+		respondHealth(w, r, &health{Status: "draining", Checks: map[string]*checkResult{}})
+		return
+	}
+	respondHealth(w, r, s.checkHealth())
+}
+
+// checkHealth runs the checks.
+func (s *Server) checkHealth() *health {
+	checks, ok := s.runChecks()
+	if !ok {
+		return &health{Status: "down", Checks: checks}
+	}
+	return &health{Status: "ok", Checks: checks}
+}
+
+func respondHealth(w http.ResponseWriter, r *http.Request, h *health) {
+	status := http.StatusOK
+	if h.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respond(w, r, status, h)
+}
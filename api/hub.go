@@ -0,0 +1,159 @@
+package api
+
+import (
+	"fmt"
+	"socialpoll/results"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// watcherBuffer is how many events a watcher may fall
+	// behind before it is dropped.
+	watcherBuffer = 64
+	// replayEvents is how many recent events of each poll
+	// are kept for reconnecting watchers.
+	replayEvents = 64
+	// replayWindow is how long the recent events of a poll
+	// are kept once nobody watches it.
+	replayWindow = 1 * time.Minute
+)
+
+// delta is an event telling the votes a batch added to the
+// results of a poll.
+type delta struct {
+	seq    uint64
+	Poll   string         `json:"poll"`
+	Batch  string         `json:"batch"`
+	Time   time.Time      `json:"time"`
+	Counts map[string]int `json:"counts"`
+}
+
+// watcher receives the deltas of a poll. Its events channel
+// is closed when it falls too far behind, so the stream ends
+// and the client reconnects.
+type watcher struct {
+	events chan *delta
+}
+
+// pollWatchers are the watchers of a poll, along with its
+// recent deltas.
+type pollWatchers struct {
+	watchers  map[*watcher]bool
+	recent    []*delta
+	trimmed   uint64    // sequence number of the last delta dropped from recent
+	idleSince time.Time // when the last watcher left
+}
+
+// hub fans the results updates out to the watchers of
+// each poll, and keeps the recent deltas of the polls watched
+// so that clients can resume where they left off.
+// Events are numbered in the order the hub received them,
+// prefixed by an ID telling hubs, and so processes, apart.
+type hub struct {
+	prefix string
+
+	lock  sync.Mutex // protects the fields below
+	seq   uint64
+	polls map[string]*pollWatchers
+}
+
+func newHub() *hub {
+	return &hub{
+		prefix: strconv.FormatInt(time.Now().UnixNano(), 36),
+		polls:  make(map[string]*pollWatchers),
+	}
+}
+
+// eventID returns the ID of the event with the sequence number.
+func (h *hub) eventID(seq uint64) string {
+	return fmt.Sprintf("%s-%d", h.prefix, seq)
+}
+
+// parseEventID returns the sequence number of an event ID
+// given by this hub.
+func (h *hub) parseEventID(id string) (uint64, bool) {
+	if !strings.HasPrefix(id, h.prefix+"-") {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(id[len(h.prefix)+1:], 10, 64)
+	return seq, err == nil
+}
+
+// watch adds a watcher of the poll. If lastEventID names an
+// event of this hub whose successors are all still kept, they
+// are returned, and ok is true; otherwise the client needs a
+// snapshot of the results, as of the returned sequence number.
+func (h *hub) watch(poll, lastEventID string) (w *watcher, replay []*delta, seq uint64, ok bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	pw, found := h.polls[poll]
+	if !found {
+		pw = &pollWatchers{watchers: make(map[*watcher]bool), trimmed: h.seq}
+		h.polls[poll] = pw
+	}
+	w = &watcher{events: make(chan *delta, watcherBuffer)}
+	pw.watchers[w] = true
+	if last, valid := h.parseEventID(lastEventID); valid && last >= pw.trimmed && last <= h.seq {
+		for _, d := range pw.recent {
+			if d.seq > last {
+				replay = append(replay, d)
+			}
+		}
+		return w, replay, h.seq, true
+	}
+	return w, nil, h.seq, false
+}
+
+// unwatch removes the watcher of the poll.
+func (h *hub) unwatch(poll string, w *watcher) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	pw, ok := h.polls[poll]
+	if !ok {
+		return
+	}
+	delete(pw.watchers, w)
+	if len(pw.watchers) == 0 {
+		pw.idleSince = time.Now()
+	}
+}
+
+// publish sends the deltas of the update to the watchers of
+// the polls, dropping the watchers that cannot keep up.
+func (h *hub) publish(u *results.Update) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	now := time.Now()
+	for id, pw := range h.polls {
+		if len(pw.watchers) == 0 && now.Sub(pw.idleSince) > replayWindow {
+			delete(h.polls, id)
+		}
+	}
+	for id, counts := range u.Counts {
+		// numbered even if nobody watches the poll, so clients
+		// resuming it once it is forgotten get a snapshot
+		h.seq++
+		pw, ok := h.polls[id]
+		if !ok {
+			continue
+		}
+		d := &delta{seq: h.seq, Poll: id, Batch: u.Batch, Time: u.Time, Counts: counts}
+		pw.recent = append(pw.recent, d)
+		if len(pw.recent) > replayEvents {
+			pw.trimmed = pw.recent[0].seq
+			pw.recent = pw.recent[1:]
+		}
+		for w := range pw.watchers {
+			select {
+			case w.events <- d:
+			default:
+				// too slow; the client will reconnect
+				close(w.events)
+				delete(pw.watchers, w)
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"socialpoll/results"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// publishBatch publishes an update adding a vote for the
+// option of each poll.
+func publishBatch(h *hub, batch, option string, polls ...string) {
+	u := &results.Update{Batch: batch, Time: time.Now(), Counts: map[string]map[string]int{}}
+	for _, p := range polls {
+		u.Counts[p] = map[string]int{option: 1}
+	}
+	h.publish(u)
+}
+
+func deltaBatches(deltas []*delta) []string {
+	var batches []string
+	for _, d := range deltas {
+		batches = append(batches, d.Batch)
+	}
+	return batches
+}
+
+func TestHubResume(t *testing.T) {
+	h := newHub()
+	w, replay, seq, ok := h.watch("p1", "")
+	if ok || replay != nil {
+		t.Fatalf("expected a snapshot without Last-Event-ID")
+	}
+	snapshot := h.eventID(seq)
+	publishBatch(h, "b1", "vim", "p1", "p2")
+	publishBatch(h, "b2", "emacs", "p2")
+	publishBatch(h, "b3", "vim", "p1")
+	var ids []string
+	for i := 0; i < 2; i++ {
+		d := <-w.events
+		ids = append(ids, h.eventID(d.seq))
+	}
+	h.unwatch("p1", w)
+
+	tests := []struct {
+		lastEventID string
+		resumed     bool
+		batches     []string
+	}{
+		{snapshot, true, []string{"b1", "b3"}},
+		{ids[0], true, []string{"b3"}},
+		{ids[1], true, nil},
+		// unknown to this hub, such as one of another process
+		{"other-" + strconv.FormatUint(seq, 10), false, nil},
+		{h.eventID(seq + 100), false, nil},
+		{h.prefix + "-x", false, nil},
+		{"", false, nil},
+	}
+	for _, test := range tests {
+		w, replay, _, ok := h.watch("p1", test.lastEventID)
+		h.unwatch("p1", w)
+		if ok != test.resumed {
+			t.Errorf("%q: expected resumed %v, got %v", test.lastEventID, test.resumed, ok)
+			continue
+		}
+		if got := deltaBatches(replay); len(got) != len(test.batches) ||
+			(len(got) > 0 && got[len(got)-1] != test.batches[len(test.batches)-1]) {
+			t.Errorf("%q: expected replay of %v, got %v", test.lastEventID, test.batches, got)
+		}
+	}
+}
+
+func TestHubResumeTooOld(t *testing.T) {
+	h := newHub()
+	w, _, _, _ := h.watch("p1", "")
+	h.unwatch("p1", w)
+	publishBatch(h, "first", "vim", "p1")
+	first := h.eventID(h.seq)
+	for i := 0; i <= replayEvents; i++ {
+		publishBatch(h, "b"+strconv.Itoa(i), "vim", "p1")
+	}
+	if _, replay, _, ok := h.watch("p1", first); ok || replay != nil {
+		t.Errorf("expected a snapshot once the events after %s are dropped, got %v", first, deltaBatches(replay))
+	}
+	second := h.eventID(h.seq - replayEvents + 1)
+	_, replay, _, ok := h.watch("p1", second)
+	if !ok || len(replay) != replayEvents-1 {
+		t.Errorf("expected %d deltas after %s, got %d (resumed %v)", replayEvents-1, second, len(replay), ok)
+	}
+}
+
+func TestHubResumeForgottenPoll(t *testing.T) {
+	h := newHub()
+	w, _, seq, _ := h.watch("p1", "")
+	h.unwatch("p1", w)
+	h.polls["p1"].idleSince = time.Now().Add(-2 * replayWindow)
+	// p1 is forgotten first, so the delta is not kept
+	publishBatch(h, "b1", "vim", "p1")
+	if _, found := h.polls["p1"]; found {
+		t.Fatalf("expected the idle poll to be forgotten")
+	}
+	if _, replay, _, ok := h.watch("p1", h.eventID(seq)); ok {
+		t.Errorf("expected a snapshot of a forgotten poll, got %v", deltaBatches(replay))
+	}
+}
+
+func TestHubDropsSlowWatchers(t *testing.T) {
+	h := newHub()
+	slow, _, _, _ := h.watch("p1", "")
+	for i := 0; i <= watcherBuffer; i++ {
+		publishBatch(h, "b"+strconv.Itoa(i), "vim", "p1")
+	}
+	n := 0
+	for range slow.events {
+		n++
+	}
+	if n != watcherBuffer {
+		t.Errorf("expected %d deltas before the watcher is dropped, got %d", watcherBuffer, n)
+	}
+	// the deltas are still kept for when it resumes
+	_, replay, _, ok := h.watch("p1", h.eventID(uint64(n)))
+	if !ok || len(replay) != 1 {
+		t.Errorf("expected the missed delta to be replayed, got %d (resumed %v)", len(replay), ok)
+	}
+}
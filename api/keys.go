@@ -0,0 +1,79 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"socialpoll/apikey"
+	"socialpoll/poll"
+	"time"
+)
+
+func (s *Server) handleKeysGet(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.keys.List()
+	if err != nil {
+		respondErr(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	respond(w, r, http.StatusOK, &keys)
+}
+
+// mintedKey is the response to minting a key, the only
+// time the key itself is shown.
+type mintedKey struct {
+	*apikey.Key
+	Secret string `json:"key"`
+}
+
+func (s *Server) handleKeysPost(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Owner  string   `json:"owner"`
+		Scopes []string `json:"scopes"`
+		Tier   string   `json:"tier"`
+	}
+	if err := decodeBody(r, &req); err != nil {
+		respondBodyErr(w, r, "key", err)
+		return
+	}
+	var invalid poll.ValidationError
+	if req.Owner == "" {
+		invalid = append(invalid, &poll.FieldError{Field: "owner", Code: poll.CodeRequired, Message: "key has no owner"})
+	}
+	if len(req.Scopes) == 0 {
+		req.Scopes = []string{apikey.ScopePolls}
+	}
+	for i, scope := range req.Scopes {
+		if !apikey.ValidScope(scope) {
+			invalid = append(invalid, &poll.FieldError{
+				Field:   fmt.Sprintf("scopes[%d]", i),
+				Code:    poll.CodeUnknown,
+				Message: "unknown scope " + scope,
+			})
+		}
+	}
+	if _, ok := s.tiers[req.Tier]; req.Tier != "" && !ok {
+		invalid = append(invalid, &poll.FieldError{Field: "tier", Code: poll.CodeUnknown, Message: "unknown tier " + req.Tier})
+	}
+	if len(invalid) > 0 {
+		respondInvalid(w, r, invalid)
+		return
+	}
+	key, k, err := apikey.Mint(s.keys, req.Owner, req.Tier, req.Scopes)
+	if err != nil {
+		respondErr(w, r, http.StatusInternalServerError, "failed to mint key", err)
+		return
+	}
+	w.Header().Set("Location", "/v1/keys/"+k.ID)
+	respond(w, r, http.StatusCreated, &mintedKey{Key: k, Secret: key})
+}
+
+func (s *Server) handleKeyDelete(w http.ResponseWriter, r *http.Request) {
+	if err := s.keys.Revoke(Param(r, "id"), time.Now().UTC()); err != nil {
+		if err == apikey.ErrNotFound {
+			respondHTTPErr(w, r, http.StatusNotFound)
+			return
+		}
+		respondErr(w, r, http.StatusInternalServerError, "failed to revoke key", err)
+		return
+	}
+	respond(w, r, http.StatusOK, nil)
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"socialpoll/apikey"
+	"socialpoll/config"
+	"socialpoll/ratelimit"
+	"strconv"
+	"time"
+)
+
+// SetLimits makes the API limit the requests of every client
+// address to perIP, and those of every key, along with the
+// polls its owner may have, to the limits of its tier. Keys
+// whose tier is not in tiers get the default tier; without it,
+// they are not limited. Limits must be set before the API is
+// served.
+func (s *Server) SetLimits(tiers map[string]config.Tier, perIP ratelimit.Limit) {
+	s.tiers = tiers
+	s.ipLimit = perIP
+}
+
+// tier returns the limits of the key.
+func (s *Server) tier(key *apikey.Key) config.Tier {
+	if t, ok := s.tiers[key.Tier]; ok {
+		return t
+	}
+	return s.tiers[config.DefaultTier]
+}
+
+// withIPLimit is a wrapper of a HandlerFunc limiting the
+// requests of each client address.
+func (s *Server) withIPLimit(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.allow(w, r, "ip:"+clientIP(r), s.ipLimit) {
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// clientIP returns the address of the client, as seen
+// by the server.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// allowKey takes a token from the bucket of the key.
+func (s *Server) allowKey(w http.ResponseWriter, r *http.Request, key *apikey.Key) bool {
+	t := s.tier(key)
+	return s.allow(w, r, "key:"+key.ID, ratelimit.Limit{Rate: t.Rate, Burst: t.Burst})
+}
+
+// allow takes a token from the named bucket, describing it
+// in the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers. If the bucket is empty, it responds
+// with 429 Too Many Requests, telling when to retry in the
+// Retry-After header, and returns false.
+func (s *Server) allow(w http.ResponseWriter, r *http.Request, bucket string, limit ratelimit.Limit) bool {
+	if limit.Unlimited() {
+		return true
+	}
+	res := s.limiter.Allow(bucket, limit, time.Now())
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	if !res.Allowed {
+		retry := ceilSeconds(res.RetryAfter)
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+		respondErrCode(w, r, http.StatusTooManyRequests, "rate_limited",
+			"too many requests, retry in ", retry, "s")
+		return false
+	}
+	return true
+}
+
+// ceilSeconds returns d in whole seconds, rounded up,
+// and at least 1 if d is positive.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// withinQuota checks that the quotas of the tier of the key let
+// the owner have the given numbers of polls and options on top
+// of those they have. If not, it responds with 403 Forbidden
+// and returns false.
+func (s *Server) withinQuota(w http.ResponseWriter, r *http.Request, key *apikey.Key, owner string, polls, options int) bool {
+	t := s.tier(key)
+	if (t.Polls == 0 || polls <= 0) && (t.Options == 0 || options <= 0) {
+		return true
+	}
+	hasPolls, hasOptions, err := s.polls.Usage(owner)
+	if err != nil {
+		respondErr(w, r, http.StatusInternalServerError, "failed to check quota", err)
+		return false
+	}
+	switch {
+	case t.Polls > 0 && polls > 0 && hasPolls+polls > t.Polls:
+		respondErrCode(w, r, http.StatusForbidden, "quota_exceeded",
+			fmt.Sprintf("%s has %d of the %d polls allowed", owner, hasPolls, t.Polls))
+		return false
+	case t.Options > 0 && options > 0 && hasOptions+options > t.Options:
+		respondErrCode(w, r, http.StatusForbidden, "quota_exceeded",
+			fmt.Sprintf("%s has %d of the %d options allowed, and asks for %d more", owner, hasOptions, t.Options, options))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"socialpoll/apikey"
+	"socialpoll/poll"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultPageSize is how many polls a page of
+	// GET /polls/ holds unless a limit is given.
+	defaultPageSize = 50
+	// maxPageSize is the largest limit allowed.
+	maxPageSize = 200
+)
+
+// pollsQuery reads the query of GET /polls/ from the request.
+// Keys without the admin scope only see their owner's polls.
+func pollsQuery(r *http.Request) (poll.Query, error) {
+	v := r.URL.Query()
+	q := poll.Query{
+		Title: v.Get("title"),
+		After: v.Get("after"),
+		Limit: defaultPageSize,
+	}
+	if key, _ := APIKey(r.Context()); key.HasScope(apikey.ScopeAdmin) {
+		q.Owner = v.Get("owner")
+	} else {
+		q.Owner = key.Owner
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPageSize {
+			return q, &poll.FieldError{Field: "limit", Code: poll.CodeInvalid,
+				Message: "limit must be between 1 and " + strconv.Itoa(maxPageSize)}
+		}
+		q.Limit = n
+	}
+	if s := v.Get("status"); s != "" {
+		st, err := poll.ParseStatus(s)
+		if err != nil {
+			return q, invalidParam("status", err)
+		}
+		q.Status = st
+	}
+	if s := v.Get("sort"); s != "" {
+		o, err := poll.ParseSort(s)
+		if err != nil {
+			return q, invalidParam("sort", err)
+		}
+		q.Sort = o
+	}
+	for _, t := range []struct {
+		param string
+		time  *time.Time
+	}{
+		{"createdFrom", &q.CreatedFrom},
+		{"createdTo", &q.CreatedTo},
+	} {
+		if s := v.Get(t.param); s != "" {
+			var err error
+			if *t.time, err = time.Parse(time.RFC3339, s); err != nil {
+				return q, invalidParam(t.param, err)
+			}
+		}
+	}
+	return q, nil
+}
+
+// handlePollsList serves GET /polls/, a page of the polls
+// selected by the status, owner, title, createdFrom and
+// createdTo parameters, in the order given by sort. The Link
+// header points to the next page, if any, whose polls come
+// after the cursor given as the after parameter.
+func (s *Server) handlePollsList(w http.ResponseWriter, r *http.Request) {
+	q, err := pollsQuery(r)
+	if err != nil {
+		respondInvalid(w, r, err)
+		return
+	}
+	limit := q.Limit
+	q.Limit++ // to tell whether there is a next page
+	result, err := s.polls.List(q)
+	if err == poll.ErrInvalidCursor {
+		respondInvalid(w, r, invalidParam("after", err))
+		return
+	}
+	if err != nil {
+		respondErr(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if len(result) > limit {
+		result = result[:limit]
+		// the request URI keeps any /v1 prefix
+		next, err := url.ParseRequestURI(r.RequestURI)
+		if err != nil {
+			next = r.URL
+		}
+		v := next.Query()
+		v.Set("after", q.Cursor(result[limit-1]))
+		next.RawQuery = v.Encode()
+		w.Header().Set("Link", "<"+next.RequestURI()+`>; rel="next"`)
+	}
+	respond(w, r, http.StatusOK, result)
+}
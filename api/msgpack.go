@@ -0,0 +1,309 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// msgpackCodec encodes and decodes MessagePack, holding the
+// same values as JSON: the bodies are converted through their
+// JSON form, so field names and types are the same in both.
+// Map keys are sorted, so that equal values encode the same.
+type msgpackCodec struct{}
+
+// maxMsgpackLength bounds the strings, lists and maps read,
+// so a few bytes cannot make the decoder allocate gigabytes.
+const maxMsgpackLength = 1 << 20
+
+// maxMsgpackDepth bounds how deeply lists and maps nest, as
+// encoding/json does, so a few bytes cannot exhaust the stack.
+const maxMsgpackDepth = 10000
+
+var (
+	errMsgpackTooLong = errors.New("msgpack: value too long")
+	errMsgpackTooDeep = errors.New("msgpack: exceeded max depth")
+)
+
+func (msgpackCodec) Encode(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var generic interface{}
+	if err := d.Decode(&generic); err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	if err := writeMsgpack(bw, generic); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func (msgpackCodec) Decode(r io.Reader, v interface{}) error {
+	generic, err := readMsgpack(bufio.NewReader(r), 0)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// writeMsgpack writes a value decoded from JSON.
+func writeMsgpack(w *bufio.Writer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		return w.WriteByte(0xc0)
+	case bool:
+		if v {
+			return w.WriteByte(0xc3)
+		}
+		return w.WriteByte(0xc2)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return writeMsgpackInt(w, n)
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		w.WriteByte(0xcb)
+		return binary.Write(w, binary.BigEndian, math.Float64bits(f))
+	case string:
+		writeMsgpackHeader(w, len(v), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		_, err := w.WriteString(v)
+		return err
+	case []interface{}:
+		writeMsgpackHeader(w, len(v), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, e := range v {
+			if err := writeMsgpack(w, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		writeMsgpackHeader(w, len(v), 0x80, 15, 0, 0xde, 0xdf)
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := writeMsgpack(w, k); err != nil {
+				return err
+			}
+			if err := writeMsgpack(w, v[k]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("msgpack: cannot encode %T", v)
+}
+
+// writeMsgpackInt writes n in the shortest form.
+func writeMsgpackInt(w *bufio.Writer, n int64) error {
+	switch {
+	case n >= 0 && n <= 0x7f:
+		return w.WriteByte(byte(n))
+	case n < 0 && n >= -32:
+		return w.WriteByte(byte(n))
+	case n >= math.MinInt8 && n <= math.MaxInt8:
+		w.WriteByte(0xd0)
+		return w.WriteByte(byte(n))
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		w.WriteByte(0xd1)
+		return binary.Write(w, binary.BigEndian, int16(n))
+	case n >= math.MinInt32 && n <= math.MaxInt32:
+		w.WriteByte(0xd2)
+		return binary.Write(w, binary.BigEndian, int32(n))
+	}
+	w.WriteByte(0xd3)
+	return binary.Write(w, binary.BigEndian, n)
+}
+
+// writeMsgpackHeader writes the type and length of a string,
+// list or map of n elements: in the fixed format for lengths up
+// to fixMax, or else with a length of 8 (unless the type has no
+// such format), 16 or 32 bits.
+func writeMsgpackHeader(w *bufio.Writer, n int, fix byte, fixMax int, f8, f16, f32 byte) {
+	switch {
+	case n <= fixMax:
+		w.WriteByte(fix | byte(n))
+	case f8 != 0 && n <= math.MaxUint8:
+		w.WriteByte(f8)
+		w.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		w.WriteByte(f16)
+		binary.Write(w, binary.BigEndian, uint16(n))
+	default:
+		w.WriteByte(f32)
+		binary.Write(w, binary.BigEndian, uint32(n))
+	}
+}
+
+// readMsgpack reads a value, as JSON would decode it: maps
+// have string keys, and numbers are json.Number. depth is how
+// many lists and maps hold the value.
+func readMsgpack(r *bufio.Reader, depth int) (interface{}, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case b <= 0x7f:
+		return json.Number(strconv.Itoa(int(b))), nil
+	case b >= 0xe0:
+		return json.Number(strconv.Itoa(int(int8(b)))), nil
+	case b >= 0xa0 && b <= 0xbf:
+		return readMsgpackString(r, int(b&0x1f))
+	case b >= 0x90 && b <= 0x9f:
+		return readMsgpackArray(r, int(b&0x0f), depth+1)
+	case b >= 0x80 && b <= 0x8f:
+		return readMsgpackMap(r, int(b&0x0f), depth+1)
+	}
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xd9, 0xc4:
+		n, err := readMsgpackUint(r, 1)
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackString(r, int(n))
+	case 0xda, 0xc5:
+		n, err := readMsgpackUint(r, 2)
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackString(r, int(n))
+	case 0xdb, 0xc6:
+		n, err := readMsgpackUint(r, 4)
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackString(r, int(n))
+	case 0xdc, 0xdd:
+		n, err := readMsgpackUint(r, 2<<(b-0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackArray(r, int(n), depth+1)
+	case 0xde, 0xdf:
+		n, err := readMsgpackUint(r, 2<<(b-0xde))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackMap(r, int(n), depth+1)
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := readMsgpackUint(r, 1<<(b-0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatUint(n, 10)), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		n, err := readMsgpackUint(r, size)
+		if err != nil {
+			return nil, err
+		}
+		// sign extend
+		shift := uint(64 - 8*size)
+		return json.Number(strconv.FormatInt(int64(n<<shift)>>shift, 10)), nil
+	case 0xca:
+		n, err := readMsgpackUint(r, 4)
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatFloat(float64(math.Float32frombits(uint32(n))), 'g', -1, 32)), nil
+	case 0xcb:
+		n, err := readMsgpackUint(r, 8)
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatFloat(math.Float64frombits(n), 'g', -1, 64)), nil
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type 0x%02x", b)
+}
+
+// readMsgpackUint reads a big endian unsigned integer of size bytes.
+func readMsgpackUint(r *bufio.Reader, size int) (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[8-size:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+func readMsgpackString(r *bufio.Reader, n int) (interface{}, error) {
+	if n > maxMsgpackLength {
+		return nil, errMsgpackTooLong
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func readMsgpackArray(r *bufio.Reader, n, depth int) (interface{}, error) {
+	if n > maxMsgpackLength {
+		return nil, errMsgpackTooLong
+	}
+	if depth > maxMsgpackDepth {
+		return nil, errMsgpackTooDeep
+	}
+	// grown as elements are read, rather than by n
+	a := []interface{}{}
+	for i := 0; i < n; i++ {
+		v, err := readMsgpack(r, depth)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+	}
+	return a, nil
+}
+
+func readMsgpackMap(r *bufio.Reader, n, depth int) (interface{}, error) {
+	if n > maxMsgpackLength {
+		return nil, errMsgpackTooLong
+	}
+	if depth > maxMsgpackDepth {
+		return nil, errMsgpackTooDeep
+	}
+	m := make(map[string]interface{})
+	for i := 0; i < n; i++ {
+		k, err := readMsgpack(r, depth)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, errors.New("msgpack: map keys must be strings")
+		}
+		v, err := readMsgpack(r, depth)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func encodeMsgpack(t *testing.T, v interface{}) []byte {
+	var buf bytes.Buffer
+	if err := (msgpackCodec{}).Encode(&buf, v); err != nil {
+		t.Fatalf("encoding %v: %v", v, err)
+	}
+	return buf.Bytes()
+}
+
+func TestMsgpackInts(t *testing.T) {
+	tests := []struct {
+		n      int64
+		format byte
+	}{
+		{0, 0x00},
+		{127, 0x7f},
+		{128, 0xd1},
+		{-1, 0xff},
+		{-32, 0xe0},
+		{-33, 0xd0},
+		{math.MinInt8, 0xd0},
+		{math.MinInt8 - 1, 0xd1},
+		{math.MaxInt16, 0xd1},
+		{math.MaxInt16 + 1, 0xd2},
+		{math.MinInt16, 0xd1},
+		{math.MinInt16 - 1, 0xd2},
+		{math.MaxInt32, 0xd2},
+		{math.MaxInt32 + 1, 0xd3},
+		{math.MinInt32, 0xd2},
+		{math.MinInt32 - 1, 0xd3},
+		{math.MaxInt64, 0xd3},
+		{math.MinInt64, 0xd3},
+	}
+	for _, test := range tests {
+		b := encodeMsgpack(t, test.n)
+		if b[0] != test.format {
+			t.Errorf("%d: expected format 0x%02x, got 0x%02x", test.n, test.format, b[0])
+		}
+		var got int64
+		if err := (msgpackCodec{}).Decode(bytes.NewReader(b), &got); err != nil {
+			t.Errorf("%d: %v", test.n, err)
+			continue
+		}
+		if got != test.n {
+			t.Errorf("%d: decoded %d", test.n, got)
+		}
+	}
+}
+
+func TestMsgpackDecodesUnsignedAndFloat32(t *testing.T) {
+	tests := []struct {
+		in   []byte
+		want interface{}
+	}{
+		{[]byte{0xcc, 0xff}, uint64(math.MaxUint8)},
+		{[]byte{0xcd, 0xff, 0xff}, uint64(math.MaxUint16)},
+		{[]byte{0xce, 0xff, 0xff, 0xff, 0xff}, uint64(math.MaxUint32)},
+		{[]byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, uint64(math.MaxUint64)},
+		{[]byte{0xca, 0x3f, 0xc0, 0x00, 0x00}, 1.5},
+		{[]byte{0xc0}, nil},
+		{[]byte{0xc2}, false},
+		{[]byte{0xc3}, true},
+	}
+	for _, test := range tests {
+		got := reflect.New(reflect.TypeOf(&test.want).Elem())
+		if test.want != nil {
+			got = reflect.New(reflect.TypeOf(test.want))
+		}
+		if err := (msgpackCodec{}).Decode(bytes.NewReader(test.in), got.Interface()); err != nil {
+			t.Errorf("% x: %v", test.in, err)
+			continue
+		}
+		if v := got.Elem().Interface(); !reflect.DeepEqual(v, test.want) {
+			t.Errorf("% x: expected %v, got %v", test.in, test.want, v)
+		}
+	}
+}
+
+func TestMsgpackLengths(t *testing.T) {
+	tests := []struct {
+		name   string
+		v      interface{}
+		format byte
+	}{
+		{"fixstr", strings.Repeat("a", 31), 0xbf},
+		{"str8", strings.Repeat("a", 32), 0xd9},
+		{"str8 max", strings.Repeat("a", 255), 0xd9},
+		{"str16", strings.Repeat("a", 256), 0xda},
+		{"str32", strings.Repeat("a", 1<<16), 0xdb},
+		{"fixarray", make([]int, 15), 0x9f},
+		{"array16", make([]int, 16), 0xdc},
+		{"array16 max", make([]int, math.MaxUint16), 0xdc},
+		{"array32", make([]int, 1<<16), 0xdd},
+		{"fixmap", intMap(15), 0x8f},
+		{"map16", intMap(16), 0xde},
+		{"map32", intMap(1 << 16), 0xdf},
+	}
+	for _, test := range tests {
+		b := encodeMsgpack(t, test.v)
+		if b[0] != test.format {
+			t.Errorf("%s: expected format 0x%02x, got 0x%02x", test.name, test.format, b[0])
+		}
+		got := reflect.New(reflect.TypeOf(test.v))
+		if err := (msgpackCodec{}).Decode(bytes.NewReader(b), got.Interface()); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got.Elem().Interface(), test.v) {
+			t.Errorf("%s: value changed in round trip", test.name)
+		}
+	}
+}
+
+func intMap(n int) map[string]int {
+	m := make(map[string]int, n)
+	for i := 0; i < n; i++ {
+		m[strings.Repeat("k", i%5)+string(rune('a'+i%26))+string(rune('a'+i/26%26))+string(rune('a'+i/676))] = i
+	}
+	return m
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	type inner struct {
+		Counts map[string]map[string]int `json:"counts"`
+		Floats []float64                 `json:"floats"`
+	}
+	type value struct {
+		Title  string            `json:"title"`
+		Empty  []string          `json:"empty"`
+		Nil    *inner            `json:"nil"`
+		Inner  inner             `json:"inner"`
+		Nested []map[string]bool `json:"nested"`
+		Ratio  float64           `json:"ratio"`
+	}
+	in := value{
+		Title: "héllo",
+		Empty: []string{},
+		Inner: inner{
+			Counts: map[string]map[string]int{"p1": {"a": 1, "b": -2}, "p2": {}},
+			Floats: []float64{0.5, -1e-9, math.MaxFloat64},
+		},
+		Nested: []map[string]bool{{"x": true}, {"y": false}},
+		Ratio:  3.25,
+	}
+	b := encodeMsgpack(t, in)
+	var out value
+	if err := (msgpackCodec{}).Decode(bytes.NewReader(b), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("expected %+v, got %+v", in, out)
+	}
+	// equal values encode the same, whatever the map order
+	if again := encodeMsgpack(t, out); !bytes.Equal(b, again) {
+		t.Errorf("encoding is not deterministic")
+	}
+}
+
+func TestMsgpackRejects(t *testing.T) {
+	tooLong := []byte{0x00, 0x10, 0x00, 0x01} // maxMsgpackLength + 1
+	tests := []struct {
+		name string
+		in   []byte
+		err  error
+	}{
+		{"long str32", append([]byte{0xdb}, tooLong...), errMsgpackTooLong},
+		{"long bin32", append([]byte{0xc6}, tooLong...), errMsgpackTooLong},
+		{"long array32", append([]byte{0xdd}, tooLong...), errMsgpackTooLong},
+		{"long map32", append([]byte{0xdf}, tooLong...), errMsgpackTooLong},
+		{"truncated", []byte{0xa3, 'a'}, nil},
+		{"truncated int", []byte{0xd2, 0x00}, nil},
+		{"non-string key", []byte{0x81, 0x01, 0x01}, nil},
+		{"unsupported type", []byte{0xc1}, nil},
+		{"empty", nil, nil},
+	}
+	for _, test := range tests {
+		var v interface{}
+		err := (msgpackCodec{}).Decode(bytes.NewReader(test.in), &v)
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+			continue
+		}
+		if test.err != nil && err != test.err {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+	}
+	// a length at the limit is read
+	var s string
+	b := encodeMsgpack(t, strings.Repeat("a", maxMsgpackLength))
+	if err := (msgpackCodec{}).Decode(bytes.NewReader(b), &s); err != nil || len(s) != maxMsgpackLength {
+		t.Errorf("expected a string of %d bytes, got %d (%v)", maxMsgpackLength, len(s), err)
+	}
+}
+
+func TestMsgpackRejectsDeepNesting(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+	}{
+		{"fixarrays", bytes.Repeat([]byte{0x91}, 4<<20)},
+		{"fixmaps", bytes.Repeat([]byte{0x81, 0xa1, 'a'}, maxMsgpackDepth+1)},
+		{"array16s", bytes.Repeat([]byte{0xdc, 0, 1}, maxMsgpackDepth+1)},
+	}
+	for _, test := range tests {
+		var v interface{}
+		if err := (msgpackCodec{}).Decode(bytes.NewReader(test.in), &v); err != errMsgpackTooDeep {
+			t.Errorf("%s: expected errMsgpackTooDeep, got %v", test.name, err)
+		}
+	}
+	// nesting up to the limit is read
+	in := append(bytes.Repeat([]byte{0x91}, maxMsgpackDepth), 0xc0)
+	var v interface{}
+	if err := (msgpackCodec{}).Decode(bytes.NewReader(in), &v); err != nil {
+		t.Errorf("expected %d nested lists to be read, got %v", maxMsgpackDepth, err)
+	}
+}
@@ -1,107 +1,146 @@
-package main
+package api
 
 import (
-	"gopkg.in/mgo.v2/bson"
 	"net/http"
-	"gopkg.in/mgo.v2"
-	"fmt"
+	"socialpoll/apikey"
+	"socialpoll/poll"
 )
 
-type poll struct {
-	ID      bson.ObjectId  `bson:"_id" json:"id"`
-	Title   string         `json:"title"`
-	Options []string       `json:"options"`
-	Results map[string]int `json:"results,omitempty"`
-	APIKey  string         `json:"apikey"`
+// handlePollGet serves GET /polls/{id}, responding with a
+// list holding the poll.
+func (s *Server) handlePollGet(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.ownedPoll(w, r, Param(r, "id"))
+	if !ok {
+		return
+	}
+	respondTagged(w, r, http.StatusOK, etag(p), onePoll{p})
 }
 
-func (s *Server) handlePolls(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case "GET":
-		s.handlePollsGet(w, r)
+// onePoll is the body of GET /polls/{id}: a list holding
+// the poll, as clients predating the other routes expect,
+// but encoded as a single poll where lists differ.
+type onePoll [1]*poll.Poll
+
+func (s *Server) handlePollsPost(w http.ResponseWriter, r *http.Request) {
+	var p poll.Poll
+	if err := decodeBody(r, &p); err != nil {
+		respondBodyErr(w, r, "poll", err)
 		return
-	case "POST":
-		s.handlePollsPost(w, r)
+	}
+	if err := p.Validate(); err != nil {
+		respondInvalid(w, r, err)
 		return
-	case "DELETE":
-		s.handlePollsDelete(w, r)
+	}
+	switch p.Status = p.Status.Normalize(); p.Status {
+	case poll.Draft, poll.Open:
+	default:
+		respondInvalid(w, r, &poll.FieldError{
+			Field:   "status",
+			Code:    poll.CodeInvalid,
+			Message: "new polls must be draft or open",
+		})
 		return
-	case "OPTIONS":
-		// If the browser asks for permissions to send a
-		// DELETE request, the API will respond by setting
-		// the Access-Control-Allow-Methods header to DELETE,
-		// thus overriding the default * value that is set
-		// in the withCORS wrapper handler.
-		w.Header().Add("Access-Control-Allow-Methods",  "DELETE")
-		respond(w, r, http.StatusOK, nil)
+	}
+	key, _ := APIKey(r.Context())
+	if !s.withinQuota(w, r, key, key.Owner, 1, len(p.Options)) {
 		return
 	}
-
-	// not found
-	respondHTTPErr(w, r, http.StatusNotFound)
+	p.Owner = key.Owner
+	p.APIKey = key.ID
+	p.Results = nil
+	p.ArchivedResults = nil
+	p.Total = 0
+	p.Version = 0
+	p.Batches = nil
+	if err := s.polls.Create(&p); err != nil {
+		respondErr(w, r, http.StatusInternalServerError, "failed to insert poll", err)
+		return
+	}
+	w.Header().Set("Location", "/v1/polls/"+p.ID.Hex())
+	respond(w, r, http.StatusCreated, nil)
 }
 
-func (s *Server) handlePollsGet(w http.ResponseWriter, r *http.Request) {
-	session := s.db.Copy()
-	defer session.Close()
-
-	c := session.DB("ballots").C("polls")
-	var q *mgo.Query
-	p := NewPath(r.URL.Path)
-	if p.HasID() {
-		// get specific poll
-		q = c.FindId(bson.ObjectIdHex(p.ID))
-	} else {
-		// get all polls
-		q = c.Find(nil)
+func (s *Server) handlePollDelete(w http.ResponseWriter, r *http.Request) {
+	id := Param(r, "id")
+	if _, ok := s.ownedPoll(w, r, id); !ok {
+		return
 	}
-
-	var result []*poll
-	if err := q.All(&result); err != nil {
-		respondErr(w, r, http.StatusInternalServerError, err)
+	if err := s.polls.Delete(id); err != nil {
+		if err == poll.ErrNotFound {
+			respondHTTPErr(w, r, http.StatusNotFound)
+			return
+		}
+		respondErr(w, r, http.StatusInternalServerError, "failed to delete poll", err)
 		return
 	}
-	respond(w, r, http.StatusOK, &result)
+	respond(w, r, http.StatusOK, nil) // ok
 }
 
-func (s *Server) handlePollsPost(w http.ResponseWriter, r *http.Request) {
-	session := s.db.Copy()
-	defer session.Close()
+// transitionActions maps the actions of
+// POST /polls/{id}/{action} to the status they
+// move the poll to.
+var transitionActions = map[string]poll.Status{
+	"open":    poll.Open,
+	"close":   poll.Closed,
+	"archive": poll.Archived,
+}
 
-	c := session.DB("ballots").C("polls")
-	var p poll
-	if err := decodeBody(r, &p); err != nil {
-		respondErr(w, r, http.StatusBadRequest, "failed tp read poll from request", err)
+// handlePollTransition returns the handler moving a poll
+// to status to, responding with the updated poll.
+func (s *Server) handlePollTransition(to poll.Status) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.transitionPoll(w, r, Param(r, "id"), to)
+	}
+}
+
+func (s *Server) transitionPoll(w http.ResponseWriter, r *http.Request, id string, to poll.Status) {
+	p, ok := s.ownedPoll(w, r, id)
+	if !ok {
 		return
 	}
-	apikey, ok := APIKey(r.Context())
-	if ok {
-		p.APIKey = apikey
+	from := p.Status.Normalize()
+	if !from.CanBecome(to) {
+		respondErrCode(w, r, http.StatusConflict, "invalid_transition", "cannot move poll from ", from, " to ", to)
+		return
 	}
-	p.ID = bson.NewObjectId()
-	if err := c.Insert(p); err != nil {
-		respondErr(w, r, http.StatusInternalServerError, "failed to insert poll", err)
+	if err := s.polls.SetStatus(id, from, to); err != nil {
+		switch err {
+		case poll.ErrNotFound:
+			respondHTTPErr(w, r, http.StatusNotFound)
+		case poll.ErrConflict:
+			respondErr(w, r, http.StatusConflict, "poll status changed, try again")
+		default:
+			respondErr(w, r, http.StatusInternalServerError, "failed to update poll", err)
+		}
 		return
 	}
-	w.Header().Set("Location", "polls/"+p.ID.Hex())
-	respond(w, r, http.StatusCreated, nil)
+	p.Status = to
+	p.Version++
+	respondTagged(w, r, http.StatusOK, etag(p), p)
 }
 
-func (s *Server) handlePollsDelete(w http.ResponseWriter, r *http.Request) {
-	session := s.db.Copy()
-	defer session.Close()
-
-	c := session.DB("ballots").C("polls")
-
-	p := NewPath(r.URL.Path)
-	if !p.HasID() {
-		respondErr(w, r, http.StatusMethodNotAllowed, "cannot delete all polls")
-		return
+// ownedPoll gets the poll with the given ID, responding with
+// 404 Not Found if it does not exist or belongs to someone the
+// key of the request may not act for.
+func (s *Server) ownedPoll(w http.ResponseWriter, r *http.Request, id string) (*poll.Poll, bool) {
+	p, err := s.polls.Get(id)
+	if err == poll.ErrNotFound {
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return nil, false
 	}
-	if err := c.RemoveId(bson.ObjectIdHex(p.ID)); err != nil {
-		respondErr(w, r, http.StatusInternalServerError, "failed to delete poll", err)
-		return
+	if err != nil {
+		respondErr(w, r, http.StatusInternalServerError, err)
+		return nil, false
 	}
-	respond(w, r, http.StatusOK, nil) // ok
+	if key, ok := APIKey(r.Context()); !ok || !canAccess(key, p) {
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return nil, false
+	}
+	return p, true
+}
 
+// canAccess reports whether key may see and change p.
+// Admin keys may access every poll.
+func canAccess(key *apikey.Key, p *poll.Poll) bool {
+	return key.HasScope(apikey.ScopeAdmin) || p.Owner == key.Owner
 }
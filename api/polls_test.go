@@ -0,0 +1,421 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"socialpoll/apikey"
+	"socialpoll/poll"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fieldErrors returns the code of each field of the
+// validation error response.
+func fieldErrors(t *testing.T, w *httptest.ResponseRecorder) map[string]string {
+	var body struct {
+		Error *apiError `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error == nil {
+		t.Fatalf("expected an error response, got %d %s", w.Code, w.Body)
+	}
+	fields := make(map[string]string)
+	for _, f := range body.Error.Fields {
+		fields[f.Field] = f.Code
+	}
+	return fields
+}
+
+// getPoll returns the poll at the path.
+func (a *testAPI) getPoll(t *testing.T, path, key string) *poll.Poll {
+	w := a.do("GET", path, key, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: expected 200, got %d %s", path, w.Code, w.Body)
+	}
+	var polls []*poll.Poll
+	if err := json.Unmarshal(w.Body.Bytes(), &polls); err != nil || len(polls) != 1 {
+		t.Fatalf("GET %s: expected a poll, got %s", path, w.Body)
+	}
+	return polls[0]
+}
+
+func TestPollsPost(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+		fields map[string]string
+	}{
+		{"valid", `{"title":"Editors","options":["vim","emacs"]}`, http.StatusCreated, "", nil},
+		{"open", `{"title":"Editors","options":["vim"],"status":"open"}`, http.StatusCreated, "", nil},
+		{"not JSON", `{"title":`, http.StatusBadRequest, "invalid_json", nil},
+		{"no title", `{"options":["vim"]}`, http.StatusBadRequest, "invalid",
+			map[string]string{"title": poll.CodeRequired}},
+		{"no options", `{"title":"Editors"}`, http.StatusBadRequest, "invalid",
+			map[string]string{"options": poll.CodeRequired}},
+		{"duplicate options", `{"title":"Editors","options":["vim","vim"]}`, http.StatusBadRequest, "invalid",
+			map[string]string{"options[1]": poll.CodeDuplicate}},
+		{"dotted option", `{"title":"Runtimes","options":["node.js","deno"]}`, http.StatusBadRequest, "invalid",
+			map[string]string{"options[0]": poll.CodeInvalidChars}},
+		{"operator option", `{"title":"Mongo","options":["$where"]}`, http.StatusBadRequest, "invalid",
+			map[string]string{"options[0]": poll.CodeInvalidChars}},
+		{"unknown match rule", `{"title":"Editors","options":["vim"],"match":{"nano":{}}}`, http.StatusBadRequest, "invalid",
+			map[string]string{"match.nano": poll.CodeUnknown}},
+		{"closed", `{"title":"Editors","options":["vim"],"status":"closed"}`, http.StatusBadRequest, "invalid",
+			map[string]string{"status": poll.CodeInvalid}},
+	}
+	for _, test := range tests {
+		a := newTestAPI(t)
+		key := a.mint(t, "alice", apikey.ScopePolls)
+		w := a.do("POST", "/v1/polls", key, test.body)
+		if w.Code != test.status {
+			t.Errorf("%s: expected %d, got %d %s", test.name, test.status, w.Code, w.Body)
+			continue
+		}
+		if test.status != http.StatusCreated {
+			if code := errorCodeOf(t, w); code != test.code {
+				t.Errorf("%s: expected code %s, got %s", test.name, test.code, code)
+			}
+			for field, code := range test.fields {
+				if got := fieldErrors(t, w)[field]; got != code {
+					t.Errorf("%s: expected %s for %s, got %q", test.name, code, field, got)
+				}
+			}
+			continue
+		}
+		p := a.getPoll(t, w.Header().Get("Location"), key)
+		if p.Owner != "alice" || p.Title == "" || len(p.Options) == 0 {
+			t.Errorf("%s: unexpected poll %+v", test.name, p)
+		}
+	}
+}
+
+func TestPollsPostIgnoresCountedFields(t *testing.T) {
+	a := newTestAPI(t)
+	key := a.mint(t, "alice", apikey.ScopePolls)
+	w := a.do("POST", "/v1/polls", key,
+		`{"title":"Editors","options":["vim"],"results":{"vim":100},"total":100,"owner":"bob","version":7}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", w.Code, w.Body)
+	}
+	p := a.getPoll(t, w.Header().Get("Location"), key)
+	if len(p.Results) != 0 || p.Total != 0 || p.Owner != "alice" || p.Version != 0 {
+		t.Errorf("expected a new poll of alice without votes, got %+v", p)
+	}
+}
+
+func TestPollOwnership(t *testing.T) {
+	a := newTestAPI(t)
+	alice := a.mint(t, "alice", apikey.ScopePolls)
+	bob := a.mint(t, "bob", apikey.ScopePolls)
+	admin := a.mint(t, "root", apikey.ScopeAdmin)
+	keysOnly := a.mint(t, "carol")
+	path := a.createTestPoll(t, alice, "Editors", "vim", "emacs")
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		body   string
+		status int
+	}{
+		{"owner", "GET", path, alice, "", http.StatusOK},
+		{"admin", "GET", path, admin, "", http.StatusOK},
+		{"other owner", "GET", path, bob, "", http.StatusNotFound},
+		{"other owner's results", "GET", path + "/results", bob, "", http.StatusNotFound},
+		{"other owner edits", "PATCH", path, bob, `{"title":"Mine","version":0}`, http.StatusNotFound},
+		{"other owner closes", "POST", path + "/close", bob, "", http.StatusNotFound},
+		{"other owner deletes", "DELETE", path, bob, "", http.StatusNotFound},
+		{"no key", "GET", path, "", "", http.StatusUnauthorized},
+		{"unknown key", "GET", path, "nope", "", http.StatusUnauthorized},
+		{"key without scope", "GET", path, keysOnly, "", http.StatusForbidden},
+		{"unknown poll", "GET", "/v1/polls/" + strings.Repeat("0", 24), alice, "", http.StatusNotFound},
+		{"invalid ID", "GET", "/v1/polls/nope", alice, "", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		w := a.do(test.method, test.path, test.key, test.body)
+		if w.Code != test.status {
+			t.Errorf("%s: expected %d, got %d %s", test.name, test.status, w.Code, w.Body)
+		}
+	}
+	// the poll is left as it was
+	if p := a.getPoll(t, path, alice); p.Title != "Editors" || p.Status != poll.Open {
+		t.Errorf("poll changed by another owner: %+v", p)
+	}
+}
+
+func TestPollEdit(t *testing.T) {
+	a := newTestAPI(t)
+	key := a.mint(t, "alice", apikey.ScopePolls)
+	path := a.createTestPoll(t, key, "Editors", "vim", "emacs")
+	tests := []struct {
+		name    string
+		method  string
+		body    string
+		headers []string
+		status  int
+		code    string
+		title   string
+	}{
+		{"no version", "PATCH", `{"title":"Text editors"}`, nil,
+			http.StatusPreconditionRequired, "version_required", "Editors"},
+		{"stale If-Match", "PATCH", `{"title":"Text editors"}`, []string{"If-Match", `"3"`},
+			http.StatusPreconditionFailed, "version_mismatch", "Editors"},
+		{"stale version", "PATCH", `{"title":"Text editors","version":3}`, nil,
+			http.StatusPreconditionFailed, "version_mismatch", "Editors"},
+		{"invalid option", "PATCH", `{"options":["vim","emacs","node.js"]}`, []string{"If-Match", `"0"`},
+			http.StatusBadRequest, "invalid", "Editors"},
+		{"not JSON", "PATCH", `{"title"`, []string{"If-Match", `"0"`},
+			http.StatusBadRequest, "invalid_json", "Editors"},
+		{"patch", "PATCH", `{"title":"Text editors"}`, []string{"If-Match", `"0"`},
+			http.StatusOK, "", "Text editors"},
+		{"patch again with the old tag", "PATCH", `{"title":"Editors"}`, []string{"If-Match", `"0"`},
+			http.StatusPreconditionFailed, "version_mismatch", "Text editors"},
+		{"any version", "PATCH", `{"title":"Best editors"}`, []string{"If-Match", `*`},
+			http.StatusOK, "", "Best editors"},
+		{"options added to an open poll", "PATCH", `{"options":["vim","emacs","nano"]}`, []string{"If-Match", `"2"`},
+			http.StatusBadRequest, "invalid", "Best editors"},
+		{"put", "PUT", `{"title":"Editors","options":["vim","emacs"],"version":2}`, nil,
+			http.StatusOK, "", "Editors"},
+	}
+	for _, test := range tests {
+		before := a.getPoll(t, path, key)
+		w := a.do(test.method, path, key, test.body, test.headers...)
+		if w.Code != test.status {
+			t.Errorf("%s: expected %d, got %d %s", test.name, test.status, w.Code, w.Body)
+			continue
+		}
+		after := a.getPoll(t, path, key)
+		if after.Title != test.title {
+			t.Errorf("%s: expected title %q, got %q", test.name, test.title, after.Title)
+		}
+		if test.status != http.StatusOK {
+			if code := errorCodeOf(t, w); code != test.code {
+				t.Errorf("%s: expected code %s, got %s", test.name, test.code, code)
+			}
+			if w.Header().Get("ETag") != "" || after.Version != before.Version {
+				t.Errorf("%s: expected the poll to be left as it was", test.name)
+			}
+			continue
+		}
+		if n, ok := tagVersion(w.Header().Get("ETag")); !ok || n != before.Version+1 {
+			t.Errorf("%s: expected an ETag of version %d, got %q", test.name, before.Version+1, w.Header().Get("ETag"))
+		}
+	}
+}
+
+func TestPollETag(t *testing.T) {
+	a := newTestAPI(t)
+	key := a.mint(t, "alice", apikey.ScopePolls)
+	path := a.createTestPoll(t, key, "Editors", "vim", "emacs")
+	id := path[strings.LastIndex(path, "/")+1:]
+	tag := func(accept string) string {
+		w := a.do("GET", path, key, "", "Accept", accept)
+		if w.Code != http.StatusOK || w.Header().Get("ETag") == "" {
+			t.Fatalf("expected a tagged poll, got %d %q", w.Code, w.Header().Get("ETag"))
+		}
+		return w.Header().Get("ETag")
+	}
+	before := tag("application/json")
+	if again := tag("application/json"); again != before {
+		t.Errorf("expected the same ETag for the same poll, got %s and %s", before, again)
+	}
+	seen := map[string]string{before: "application/json"}
+	for _, accept := range []string{"text/csv", "application/xml", "application/msgpack"} {
+		etag := tag(accept)
+		if other, ok := seen[etag]; ok {
+			t.Errorf("expected %s and %s to get different ETags, both got %s", accept, other, etag)
+		}
+		seen[etag] = accept
+	}
+
+	// votes change the poll without bumping its version
+	if _, err := a.polls.IncrementResults("b1", time.Now(), map[string]map[string]int{id: {"vim": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	after := tag("application/json")
+	if after == before {
+		t.Errorf("expected new votes to change the ETag %s", before)
+	}
+	// the tags still name the version for edits
+	if w := a.do("PATCH", path, key, `{"title":"Text editors"}`, "If-Match", before); w.Code != http.StatusOK {
+		t.Errorf("If-Match %s: expected 200, got %d %s", before, w.Code, w.Body)
+	}
+	if w := a.do("PATCH", path, key, `{"title":"Stale"}`, "If-Match", after); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("If-Match %s of the old version: expected 412, got %d", after, w.Code)
+	}
+	current := tag("application/json")
+	if w := a.do("PATCH", path, key, `{"title":"Weak"}`, "If-Match", "W/"+current); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected weak tags not to match, got %d", w.Code)
+	}
+	if w := a.do("PATCH", path, key, `{"title":"Best editors"}`, "If-Match", `"9", `+current); w.Code != http.StatusOK {
+		t.Errorf("expected one of the tags to match, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestPollTransitions(t *testing.T) {
+	a := newTestAPI(t)
+	key := a.mint(t, "alice", apikey.ScopePolls)
+	w := a.do("POST", "/v1/polls", key, `{"title":"Editors","options":["vim"],"status":"draft"}`)
+	path := w.Header().Get("Location")
+	tests := []struct {
+		action string
+		status int
+		want   poll.Status
+	}{
+		{"close", http.StatusConflict, poll.Draft},
+		{"open", http.StatusOK, poll.Open},
+		{"open", http.StatusConflict, poll.Open},
+		{"archive", http.StatusConflict, poll.Open},
+		{"close", http.StatusOK, poll.Closed},
+		{"open", http.StatusOK, poll.Open},
+		{"close", http.StatusOK, poll.Closed},
+		{"archive", http.StatusOK, poll.Archived},
+		{"open", http.StatusConflict, poll.Archived},
+		{"reopen", http.StatusNotFound, poll.Archived},
+	}
+	for i, test := range tests {
+		w := a.do("POST", path+"/"+test.action, key, "")
+		if w.Code != test.status {
+			t.Errorf("%d %s: expected %d, got %d %s", i, test.action, test.status, w.Code, w.Body)
+		}
+		if test.status == http.StatusConflict {
+			if code := errorCodeOf(t, w); code != "invalid_transition" {
+				t.Errorf("%d %s: expected invalid_transition, got %s", i, test.action, code)
+			}
+		}
+		if p := a.getPoll(t, path, key); p.Status != test.want {
+			t.Errorf("%d %s: expected the poll to be %s, got %s", i, test.action, test.want, p.Status)
+		}
+	}
+	if w := a.do("PATCH", path, key, `{"title":"Old editors"}`, "If-Match", "*"); w.Code != http.StatusConflict {
+		t.Errorf("expected archived polls not to be edited, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestPollDelete(t *testing.T) {
+	a := newTestAPI(t)
+	key := a.mint(t, "alice", apikey.ScopePolls)
+	path := a.createTestPoll(t, key, "Editors", "vim")
+	other := a.createTestPoll(t, key, "Shells", "bash")
+	for i, status := range []int{http.StatusOK, http.StatusNotFound} {
+		if w := a.do("DELETE", path, key, ""); w.Code != status {
+			t.Errorf("delete %d: expected %d, got %d %s", i+1, status, w.Code, w.Body)
+		}
+	}
+	if w := a.do("GET", path, key, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected the poll to be gone, got %d", w.Code)
+	}
+	a.getPoll(t, other, key)
+}
+
+// listPolls returns the titles of the polls listed at the
+// path, and the link to the next page.
+func (a *testAPI) listPolls(t *testing.T, path, key string) ([]string, string) {
+	w := a.do("GET", path, key, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: expected 200, got %d %s", path, w.Code, w.Body)
+	}
+	var polls []*poll.Poll
+	if err := json.Unmarshal(w.Body.Bytes(), &polls); err != nil {
+		t.Fatal(err)
+	}
+	titles := make([]string, len(polls))
+	for i, p := range polls {
+		titles[i] = p.Title
+	}
+	next := ""
+	if link := w.Header().Get("Link"); link != "" {
+		if !strings.HasSuffix(link, `>; rel="next"`) || !strings.HasPrefix(link, "<") {
+			t.Fatalf("unexpected Link %q", link)
+		}
+		next = link[1:strings.Index(link, ">")]
+	}
+	return titles, next
+}
+
+func TestPollsListPagination(t *testing.T) {
+	a := newTestAPI(t)
+	alice := a.mint(t, "alice", apikey.ScopePolls)
+	bob := a.mint(t, "bob", apikey.ScopePolls)
+	admin := a.mint(t, "root", apikey.ScopeAdmin)
+	for i := 0; i < 5; i++ {
+		a.createTestPoll(t, alice, "alice "+strconv.Itoa(i), "vim")
+	}
+	a.createTestPoll(t, bob, "bob", "vim")
+
+	tests := []struct {
+		name  string
+		path  string
+		key   string
+		pages []int
+	}{
+		{"one page", "/v1/polls", alice, []int{5}},
+		{"pages of two", "/v1/polls?limit=2", alice, []int{2, 2, 1}},
+		{"pages of five", "/v1/polls?limit=5", alice, []int{5}},
+		{"other owner", "/v1/polls?limit=2", bob, []int{1}},
+		{"owner filter ignored", "/v1/polls?owner=alice", bob, []int{1}},
+		{"admin", "/v1/polls?limit=4", admin, []int{4, 2}},
+		{"admin filtering by owner", "/v1/polls?limit=4&owner=alice", admin, []int{4, 1}},
+		{"title", "/v1/polls?title=alice+3", alice, []int{1}},
+	}
+	for _, test := range tests {
+		seen := make(map[string]bool)
+		var pages []int
+		for path := test.path; path != ""; {
+			var titles []string
+			titles, path = a.listPolls(t, path, test.key)
+			pages = append(pages, len(titles))
+			for _, title := range titles {
+				if seen[title] {
+					t.Errorf("%s: %s listed twice", test.name, title)
+				}
+				seen[title] = true
+			}
+			if len(pages) > 10 {
+				t.Fatalf("%s: too many pages", test.name)
+			}
+		}
+		if got, want := intsString(pages), intsString(test.pages); got != want {
+			t.Errorf("%s: expected pages of %s, got %s", test.name, want, got)
+		}
+	}
+}
+
+func intsString(ints []int) string {
+	s := make([]string, len(ints))
+	for i, n := range ints {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ",")
+}
+
+func TestPollsListInvalidQuery(t *testing.T) {
+	a := newTestAPI(t)
+	key := a.mint(t, "alice", apikey.ScopePolls)
+	tests := []struct {
+		query string
+		field string
+	}{
+		{"limit=0", "limit"},
+		{"limit=201", "limit"},
+		{"limit=ten", "limit"},
+		{"status=maybe", "status"},
+		{"sort=random", "sort"},
+		{"after=nope", "after"},
+		{"createdFrom=yesterday", "createdFrom"},
+	}
+	for _, test := range tests {
+		w := a.do("GET", "/v1/polls?"+test.query, key, "")
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d %s", test.query, w.Code, w.Body)
+			continue
+		}
+		if fields := fieldErrors(t, w); fields[test.field] == "" {
+			t.Errorf("%s: expected an error for %s, got %v", test.query, test.field, fields)
+		}
+	}
+}
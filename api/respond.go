@@ -1,51 +1,181 @@
-package main
+package api
 
 import (
-	"net/http"
-	"encoding/json"
+	"bytes"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"socialpoll/poll"
+	"strconv"
+	"strings"
 )
 
 // decodeBody abstracts away the message decoding part,
 // such that one can easily change the way messages are encoded
-// and decoded.
+// and decoded. The body is read with the codec of its
+// Content-Type, JSON by default, up to maxBodySize bytes.
 func decodeBody(r *http.Request, v interface{}) error {
 	defer r.Body.Close()
-	return json.NewDecoder(r.Body).Decode(v)
+	return requestCodecs(r).request.Decode(r.Body, v)
+}
+
+// respondBodyErr responds to a request whose body could not
+// be read as what: 413 Request Entity Too Large if it is
+// longer than maxBodySize, 400 Bad Request otherwise.
+func respondBodyErr(w http.ResponseWriter, r *http.Request, what string, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondErr(w, r, http.StatusRequestEntityTooLarge, "request body is larger than ", maxBodySize, " bytes")
+		return
+	}
+	respondErrCode(w, r, http.StatusBadRequest, "invalid_json", "failed to read ", what, " from request: ", err)
+}
+
+// unmarshalBody decodes the body of the request, already
+// read, like decodeBody does.
+func unmarshalBody(r *http.Request, body []byte, v interface{}) error {
+	return requestCodecs(r).request.Decode(bytes.NewReader(body), v)
 }
 
 // encodeBody abstracts away the message encoding part,
 // such that one can easily change the way messages are encoded
-// and decoded.
-func encodeBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
-	return json.NewEncoder(w).Encode(v)
+// and decoded. It returns v encoded with the codec the client
+// accepts, along with its content type, or errNotAcceptable if
+// the client accepts none.
+func encodeBody(w http.ResponseWriter, r *http.Request, v interface{}) ([]byte, string, error) {
+	mc := requestCodecs(r).response
+	if mc == nil {
+		return nil, "", errNotAcceptable
+	}
+	var buf bytes.Buffer
+	if err := mc.codec.Encode(&buf, v); err != nil {
+		return nil, "", err
+	}
+	contentType := mc.mediaType
+	if strings.HasPrefix(contentType, "text/") {
+		contentType += "; charset=utf-8"
+	}
+	return buf.Bytes(), contentType, nil
 }
 
+var errNotAcceptable = errors.New("no acceptable media type")
+
 // respond makes it easy to write the status code and some data
 // to the ResponseWriter object using the encodeBody helper.
+// Clients accepting no media type the data can be encoded in
+// get 406 Not Acceptable instead; errors are then sent as JSON.
 func respond(w http.ResponseWriter, r *http.Request, status int, data interface{}) {
-	w.WriteHeader(status)
-	if data != nil {
-		encodeBody(w, r, data)
+	respondTagged(w, r, status, "", data)
+}
+
+// respondTagged is like respond, also sending an ETag made of
+// the tag of the data, unless it is empty or the data cannot be
+// sent, and a digest of the body sent: each media type, and every
+// change of the body, such as new votes, gets its own ETag.
+func respondTagged(w http.ResponseWriter, r *http.Request, status int, tag string, data interface{}) {
+	if data == nil {
+		w.WriteHeader(status)
+		return
+	}
+	body, contentType, err := encodeBody(w, r, data)
+	if err != nil && status < 400 {
+		switch err {
+		case errNotAcceptable, errUnsupportedValue:
+			respondErrCode(w, r, http.StatusNotAcceptable, "not_acceptable",
+				"cannot respond in the media types accepted; try one of ",
+				strings.Join(requestCodecs(r).mediaTypes, ", "))
+		default:
+			respondErr(w, r, http.StatusInternalServerError, "failed to encode response: ", err)
+		}
+		return
+	}
+	if err != nil {
+		var buf bytes.Buffer
+		jsonCodec{}.Encode(&buf, data)
+		body, contentType = buf.Bytes(), "application/json"
 	}
+	if tag != "" {
+		w.Header().Set("ETag", representationTag(tag, contentType, body))
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// representationTag returns the entity tag tag, suffixed with
+// a digest of the body in the content type.
+func representationTag(tag, contentType string, body []byte) string {
+	h := fnv.New64a()
+	io.WriteString(h, contentType)
+	h.Write([]byte{0})
+	h.Write(body)
+	return strings.TrimSuffix(tag, `"`) + "-" + strconv.FormatUint(h.Sum64(), 36) + `"`
+}
+
+// errorCode returns the code of errors with the given status
+// that have no more specific code, such as "not_found".
+func errorCode(status int) string {
+	return strings.Replace(strings.ToLower(http.StatusText(status)), " ", "_", -1)
 }
 
 // respondErr is a helper that abstracts the error responding.
 // It is an interface similar to the respond function,
 // but the data written will be enveloped in an error object
 // in order to make it clear that something went wrong.
+// The error object carries a machine-readable code along
+// with the message.
 func respondErr(w http.ResponseWriter, r *http.Request, status int, args ...interface{}) {
-	respond(w, r, status, map[string]interface{} {
-		"error": map[string]interface{}{
-			"message": fmt.Sprint(args...),
-		},
-	})
+	respondErrCode(w, r, status, errorCode(status), args...)
+}
+
+// errorBody is the envelope of error responses.
+type errorBody struct {
+	Error *apiError `json:"error"`
 }
 
+// apiError describes what went wrong.
+type apiError struct {
+	Code    string             `json:"code"`
+	Message string             `json:"message"`
+	Fields  []*poll.FieldError `json:"fields,omitempty"`
+}
+
+// respondErrCode is like respondErr, with a specific code.
+func respondErrCode(w http.ResponseWriter, r *http.Request, status int, code string, args ...interface{}) {
+	respond(w, r, status, &errorBody{&apiError{Code: code, Message: fmt.Sprint(args...)}})
+}
+
+// respondInvalid responds with 400 Bad Request and code
+// "invalid", listing in fields what is wrong with each
+// field of the request when err is a poll.ValidationError
+// or a *poll.FieldError.
+func respondInvalid(w http.ResponseWriter, r *http.Request, err error) {
+	var fields poll.ValidationError
+	switch e := err.(type) {
+	case poll.ValidationError:
+		fields = e
+	case *poll.FieldError:
+		fields = poll.ValidationError{e}
+	default:
+		respondErrCode(w, r, http.StatusBadRequest, "invalid", err)
+		return
+	}
+	msgs := make([]string, len(fields))
+	for i, f := range fields {
+		msgs[i] = f.Error()
+	}
+	respond(w, r, http.StatusBadRequest, &errorBody{&apiError{
+		Code:    "invalid",
+		Message: strings.Join(msgs, "; "),
+		Fields:  fields,
+	}})
+}
 
 // respondHTTPErr is an HTTP-error-specific helper that
 // will generate the correct message, using the http.StatusText
 // function from the standard library.
 func respondHTTPErr(w http.ResponseWriter, r *http.Request, status int) {
 	respondErr(w, r, status, http.StatusText(status))
-}
\ No newline at end of file
+}
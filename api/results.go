@@ -0,0 +1,83 @@
+package api
+
+import (
+	"net/http"
+	"socialpoll/match"
+	"socialpoll/poll"
+)
+
+// pollResults are the votes of a poll, with every
+// option present even if it has no votes yet.
+type pollResults struct {
+	Poll            string         `json:"poll"`
+	Total           int            `json:"total"`
+	Results         map[string]int `json:"results"`
+	ArchivedResults map[string]int `json:"archivedResults,omitempty"`
+}
+
+func newPollResults(p *poll.Poll) *pollResults {
+	result := &pollResults{
+		Poll:            p.ID.Hex(),
+		Total:           p.Total,
+		Results:         make(map[string]int, len(p.Options)),
+		ArchivedResults: p.ArchivedResults,
+	}
+	for _, option := range p.Options {
+		result.Results[option] = p.Results[option]
+	}
+	return result
+}
+
+// handlePollResults serves GET /polls/{id}/results.
+func (s *Server) handlePollResults(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.ownedPoll(w, r, Param(r, "id"))
+	if !ok {
+		return
+	}
+	respond(w, r, http.StatusOK, newPollResults(p))
+}
+
+// option is an option of a poll, with its votes and
+// the rule matching it.
+type option struct {
+	Option string      `json:"option"`
+	Votes  int         `json:"votes"`
+	Match  *match.Rule `json:"match,omitempty"`
+}
+
+func newOption(p *poll.Poll, name string) *option {
+	o := &option{Option: name, Votes: p.Results[name]}
+	if rule, ok := p.Match[name]; ok {
+		o.Match = &rule
+	}
+	return o
+}
+
+// handlePollOptions serves GET /polls/{id}/options.
+func (s *Server) handlePollOptions(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.ownedPoll(w, r, Param(r, "id"))
+	if !ok {
+		return
+	}
+	result := make([]*option, len(p.Options))
+	for i, name := range p.Options {
+		result[i] = newOption(p, name)
+	}
+	respond(w, r, http.StatusOK, result)
+}
+
+// handlePollOption serves GET /polls/{id}/options/{opt}.
+func (s *Server) handlePollOption(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.ownedPoll(w, r, Param(r, "id"))
+	if !ok {
+		return
+	}
+	name := Param(r, "opt")
+	for _, o := range p.Options {
+		if o == name {
+			respond(w, r, http.StatusOK, newOption(p, name))
+			return
+		}
+	}
+	respondHTTPErr(w, r, http.StatusNotFound)
+}
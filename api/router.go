@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Router dispatches requests on their method and path.
+// Paths are matched against patterns such as
+// /polls/{id}/options/{opt}, where segments in braces
+// match any single segment, which handlers read with
+// Param. Trailing slashes are ignored.
+//
+// Router answers OPTIONS requests itself, listing the
+// methods of the path, and responds with 405 Method Not
+// Allowed, along with the Allow header, when the path
+// exists but not with the method.
+type Router struct {
+	routes []*route
+}
+
+// route holds the handlers of a pattern, by method.
+type route struct {
+	segments []string
+	handlers map[string]http.HandlerFunc
+}
+
+var contextKeyParams = &contextKey{"route-params"}
+
+// NewRouter creates a Router without routes.
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// splitPath splits a path in its segments, ignoring
+// leading and trailing slashes.
+func splitPath(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// Handle registers the handler for requests with the method
+// and a path matching pattern.
+func (rt *Router) Handle(method, pattern string, h http.HandlerFunc) {
+	segments := splitPath(pattern)
+	for _, r := range rt.routes {
+		if strings.Join(r.segments, "/") == strings.Join(segments, "/") {
+			r.handlers[method] = h
+			return
+		}
+	}
+	rt.routes = append(rt.routes, &route{
+		segments: segments,
+		handlers: map[string]http.HandlerFunc{method: h},
+	})
+}
+
+// match returns the route matching the escaped path, along
+// with the values of its parameters.
+func (rt *Router) match(escapedPath string) (*route, map[string]string) {
+	segments := splitPath(escapedPath)
+	for _, r := range rt.routes {
+		if params, ok := r.match(segments); ok {
+			return r, params
+		}
+	}
+	return nil, nil
+}
+
+func (r *route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(r.segments) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, s := range r.segments {
+		value, err := url.PathUnescape(segments[i])
+		if err != nil {
+			return nil, false
+		}
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			params[s[1:len(s)-1]] = value
+			continue
+		}
+		if s != value {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// methods lists the methods the route handles, sorted,
+// including OPTIONS, and HEAD if it handles GET.
+func (r *route) methods() []string {
+	methods := []string{"OPTIONS"}
+	for m := range r.handlers {
+		methods = append(methods, m)
+	}
+	if _, ok := r.handlers["GET"]; ok {
+		if _, ok := r.handlers["HEAD"]; !ok {
+			methods = append(methods, "HEAD")
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// Methods returns the methods allowed on the path, or
+// nil if no route matches it.
+func (rt *Router) Methods(path string) []string {
+	r, _ := rt.match(path)
+	if r == nil {
+		return nil
+	}
+	return r.methods()
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r, params := rt.match(req.URL.EscapedPath())
+	if r == nil {
+		respondHTTPErr(w, req, http.StatusNotFound)
+		return
+	}
+	allow := strings.Join(r.methods(), ", ")
+	h, ok := r.handlers[req.Method]
+	if !ok && req.Method == "HEAD" {
+		h, ok = r.handlers["GET"]
+	}
+	if !ok {
+		w.Header().Set("Allow", allow)
+		if req.Method == "OPTIONS" {
+			respond(w, req, http.StatusOK, nil)
+			return
+		}
+		respondHTTPErr(w, req, http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := context.WithValue(req.Context(), contextKeyParams, params)
+	h(w, req.WithContext(ctx))
+}
+
+// Param returns the value of the named parameter of the
+// pattern the request matched.
+func Param(r *http.Request, name string) string {
+	params, _ := r.Context().Value(contextKeyParams).(map[string]string)
+	return params[name]
+}
@@ -0,0 +1,203 @@
+// Package api implements the socialpoll HTTP API,
+// used to create, view and delete polls.
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"socialpoll/apikey"
+	"socialpoll/config"
+	"socialpoll/poll"
+	"socialpoll/ratelimit"
+	"sync"
+	"time"
+)
+
+// Server is the API server.
+// Server makes sure handlers will not make
+// database management mistakes.
+type Server struct {
+	polls    poll.Store
+	timeline poll.TimelineStore
+	keys     *apikey.Cache
+	hub      *hub
+	checks   map[string]Check
+	codecs   []*mediaCodec
+	tiers    map[string]config.Tier
+	ipLimit  ratelimit.Limit
+	limiter  *ratelimit.Limiter
+	cors     CORS
+
+	closing   chan struct{} // closed by Shutdown
+	closeOnce sync.Once
+}
+
+// contextKey helps to create uniform keys for
+// context.Context, where keys are of type interface{}
+type contextKey struct {
+	name string
+}
+
+var contextKeyAPIKey = &contextKey{"api-key"}
+
+// NewServer creates a Server serving the polls in the store,
+// and their history from the timeline store, to the clients
+// holding a key in keys. The results it streams are only
+// updated once it subscribes to the results updates.
+func NewServer(polls poll.Store, timeline poll.TimelineStore, keys *apikey.Cache) *Server {
+	s := &Server{
+		polls:    polls,
+		timeline: timeline,
+		keys:     keys,
+		hub:      newHub(),
+		limiter:  ratelimit.New(),
+		cors:     CORS{Origins: []string{"*"}},
+		closing:  make(chan struct{}),
+	}
+	s.registerDefaultCodecs()
+	return s
+}
+
+// Shutdown prepares the server to stop: /readyz fails from
+// then on, and the streams of results end, so that their
+// clients reconnect to another server. Requests in flight are
+// left to the http.Server to drain.
+func (s *Server) Shutdown() {
+	s.closeOnce.Do(func() {
+		close(s.closing)
+	})
+}
+
+func (s *Server) shuttingDown() bool {
+	select {
+	case <-s.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// Handler returns the handler serving the API under /v1/.
+// The same routes are served without the prefix for clients
+// predating versioning. The health of the server is served,
+// without a key, at /healthz and /readyz.
+func (s *Server) Handler() http.Handler {
+	rt := s.Router()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	limited := s.withIPLimit(rt.ServeHTTP)
+	mux.Handle("/v1/", http.StripPrefix("/v1", limited))
+	mux.Handle("/", limited)
+	return s.withCORS(rt, s.withCodecs(mux.ServeHTTP))
+}
+
+// Router returns the routes of the API.
+func (s *Server) Router() *Router {
+	polls := func(fn http.HandlerFunc) http.HandlerFunc {
+		return s.withAPIKey(apikey.ScopePolls, fn)
+	}
+	admin := func(fn http.HandlerFunc) http.HandlerFunc {
+		return s.withAPIKey(apikey.ScopeAdmin, fn)
+	}
+	rt := NewRouter()
+	rt.Handle("GET", "/polls", polls(s.handlePollsList))
+	rt.Handle("POST", "/polls", polls(s.handlePollsPost))
+	rt.Handle("GET", "/polls/{id}", polls(s.handlePollGet))
+	rt.Handle("PUT", "/polls/{id}", polls(s.handlePollEdit))
+	rt.Handle("PATCH", "/polls/{id}", polls(s.handlePollEdit))
+	rt.Handle("DELETE", "/polls/{id}", polls(s.handlePollDelete))
+	for action, to := range transitionActions {
+		rt.Handle("POST", "/polls/{id}/"+action, polls(s.handlePollTransition(to)))
+	}
+	rt.Handle("GET", "/polls/{id}/results", polls(s.handlePollResults))
+	rt.Handle("GET", "/polls/{id}/options", polls(s.handlePollOptions))
+	rt.Handle("GET", "/polls/{id}/options/{opt}", polls(s.handlePollOption))
+	rt.Handle("GET", "/polls/{id}/timeline", polls(s.handlePollTimeline))
+	rt.Handle("GET", "/polls/{id}/stream", polls(s.handlePollStream))
+	rt.Handle("GET", "/keys", admin(s.handleKeysGet))
+	rt.Handle("POST", "/keys", admin(s.handleKeysPost))
+	rt.Handle("DELETE", "/keys/{id}", admin(s.handleKeyDelete))
+	return rt
+}
+
+// APIKey is a helper function that, given a context,
+// extracts the key the request was made with.
+func APIKey(ctx context.Context) (*apikey.Key, bool) {
+	key, ok := ctx.Value(contextKeyAPIKey).(*apikey.Key)
+	return key, ok
+}
+
+// withAPIKey is a wrapper of a HandlerFunc that helps with
+// asking clients to provide an API key which facilitates the
+// implementation of user authentication and authorisation.
+// Only keys granting scope get through, within the rate
+// limit of their tier.
+func (s *Server) withAPIKey(scope string, fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := s.keys.Lookup(r.URL.Query().Get("key"))
+		if err == apikey.ErrNotFound {
+			respondErr(w, r, http.StatusUnauthorized, "invalid API key")
+			return
+		}
+		if err != nil {
+			respondErr(w, r, http.StatusInternalServerError, "failed to check API key", err)
+			return
+		}
+		if !s.allowKey(w, r, key) {
+			return
+		}
+		if !key.HasScope(scope) {
+			respondErr(w, r, http.StatusForbidden, "API key lacks the ", scope, " scope")
+			return
+		}
+		ctx := context.WithValue(r.Context(), contextKeyAPIKey, key)
+		fn(w, r.WithContext(ctx))
+	}
+}
+
+// Serve serves the API with srv until stop is closed, then
+// shuts down gracefully: /readyz fails for drain, so that load
+// balancers notice and stop sending requests, then new
+// connections are refused and the requests in flight get up
+// to timeout to finish.
+func (s *Server) Serve(srv *http.Server, stop <-chan struct{}, drain, timeout time.Duration) error {
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.serve(srv, ln, stop, drain, timeout)
+}
+
+// serve is Serve with a listener.
+func (s *Server) serve(srv *http.Server, ln net.Listener, stop <-chan struct{}, drain, timeout time.Duration) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(ln)
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-stop:
+	}
+	s.Shutdown()
+	select {
+	case err := <-errc:
+		return err
+	case <-time.After(drain):
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		return err
+	}
+	if err := <-errc; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"socialpoll/apikey"
+	"socialpoll/poll"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testAPI is a Server keeping everything in memory.
+type testAPI struct {
+	*Server
+	polls    *poll.MemoryStore
+	timeline *poll.MemoryTimelineStore
+	keys     *apikey.MemoryStore
+	handler  http.Handler
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	a := &testAPI{
+		polls:    poll.NewMemoryStore(),
+		timeline: poll.NewMemoryTimelineStore(),
+		keys:     apikey.NewMemoryStore(),
+	}
+	a.Server = NewServer(a.polls, a.timeline, apikey.NewCache(a.keys, time.Minute))
+	a.handler = a.Handler()
+	return a
+}
+
+// mint returns a new key of the owner with the scopes.
+func (a *testAPI) mint(t *testing.T, owner string, scopes ...string) string {
+	key, _, err := apikey.Mint(a.keys, owner, "", scopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// do serves a request with the key, a JSON body unless it is
+// empty, and the headers given as name, value pairs.
+func (a *testAPI) do(method, path, key, body string, headers ...string) *httptest.ResponseRecorder {
+	if key != "" {
+		sep := "?"
+		if strings.Contains(path, "?") {
+			sep = "&"
+		}
+		path += sep + "key=" + key
+	}
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	a.handler.ServeHTTP(w, r)
+	return w
+}
+
+// errorCodeOf returns the code of the error response.
+func errorCodeOf(t *testing.T, w *httptest.ResponseRecorder) string {
+	var body struct {
+		Error *apiError `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error == nil {
+		t.Fatalf("expected an error response, got %d %s", w.Code, w.Body)
+	}
+	return body.Error.Code
+}
+
+func TestServeDrainsBeforeShuttingDown(t *testing.T) {
+	s := newTestAPI(t).Server
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + ln.Addr().String() + "/readyz"
+	srv := &http.Server{Handler: s.Handler()}
+	stop := make(chan struct{})
+	const drain = 300 * time.Millisecond
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.serve(srv, ln, stop, drain, time.Second)
+	}()
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	readiness := func() (int, string) {
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var h health
+		if err := json.NewDecoder(resp.Body).Decode(&h); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, h.Status
+	}
+	if code, status := readiness(); code != http.StatusOK || status != "ok" {
+		t.Fatalf("expected 200 ok before stopping, got %d %s", code, status)
+	}
+
+	stopped := time.Now()
+	close(stop)
+	// the server keeps serving while draining, failing readiness
+	time.Sleep(drain / 3)
+	if code, status := readiness(); code != http.StatusServiceUnavailable || status != "draining" {
+		t.Errorf("expected 503 draining while draining, got %d %s", code, status)
+	}
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return")
+	}
+	if elapsed := time.Since(stopped); elapsed < drain {
+		t.Errorf("Serve returned after %v, before the drain delay of %v", elapsed, drain)
+	}
+	if _, err := client.Get(url); err == nil {
+		t.Error("expected connections to be refused after shutdown")
+	}
+}
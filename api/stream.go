@@ -0,0 +1,198 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"socialpoll/bus"
+	"socialpoll/results"
+	"time"
+)
+
+const (
+	// heartbeatInterval is how often idle streams are pinged,
+	// keeping proxies from closing them.
+	heartbeatInterval = 15 * time.Second
+	// retryInterval is how long EventSource clients wait
+	// before reconnecting.
+	retryInterval = 3 * time.Second
+	// streamWriteTimeout is how long writing an event may take.
+	streamWriteTimeout = 10 * time.Second
+)
+
+// Subscribe feeds the results updates published on the channel
+// of the topic to the clients streaming the results of polls.
+func (s *Server) Subscribe(b bus.Bus, topic, channel string) (bus.Consumer, error) {
+	return b.NewConsumer(topic, channel, func(m bus.Message) error {
+		u, err := results.Decode(m.Body())
+		if err != nil {
+			// requeuing would not help
+			log.Println("ignoring bad results update:", err)
+			return nil
+		}
+		s.hub.publish(u)
+		return nil
+	})
+}
+
+// eventStream sends events to a client.
+type eventStream interface {
+	// send sends the event with the ID and data.
+	send(id, event string, data interface{}) error
+	// ping keeps the stream alive.
+	ping() error
+	// done is closed once the client went away.
+	done() <-chan struct{}
+}
+
+// handlePollStream serves GET /polls/{id}/stream, pushing the
+// results of the poll as they are counted: a "results" event
+// holding all of them, followed by a "delta" event for every
+// batch of votes added. Clients resuming with the ID of the
+// last event they received, in the Last-Event-ID header or
+// the lastEventId parameter, get the deltas they missed instead
+// of the results, if they are still known.
+//
+// Events are sent as Server-Sent Events, or over a WebSocket
+// if the request asks for an upgrade.
+func (s *Server) handlePollStream(w http.ResponseWriter, r *http.Request) {
+	id := Param(r, "id")
+	if _, ok := s.ownedPoll(w, r, id); !ok {
+		return
+	}
+	var stream eventStream
+	if isWebSocket(r) {
+		ws, ok := upgradeWebSocket(w, r)
+		if !ok {
+			return
+		}
+		defer ws.close()
+		stream = ws
+	} else {
+		sse, ok := newSSEStream(w, r)
+		if !ok {
+			return
+		}
+		stream = sse
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	watcher, replay, seq, resumed := s.hub.watch(id, lastEventID)
+	defer s.hub.unwatch(id, watcher)
+
+	// deltas the results already hold are skipped
+	var counted []string
+	if resumed {
+		for _, d := range replay {
+			if err := stream.send(s.hub.eventID(d.seq), "delta", d); err != nil {
+				return
+			}
+		}
+	} else {
+		// the watcher is added first, so no update
+		// falls between the results and the deltas
+		p, err := s.polls.Get(id)
+		if err != nil {
+			return
+		}
+		counted = p.Batches
+		if err := stream.send(s.hub.eventID(seq), "results", newPollResults(p)); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case d, ok := <-watcher.events:
+			if !ok {
+				// too far behind; the client resumes
+				return
+			}
+			if containsBatch(counted, d.Batch) {
+				continue
+			}
+			if err := stream.send(s.hub.eventID(d.seq), "delta", d); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := stream.ping(); err != nil {
+				return
+			}
+		case <-stream.done():
+			return
+		case <-s.closing:
+			return
+		}
+	}
+}
+
+func containsBatch(batches []string, batch string) bool {
+	for _, b := range batches {
+		if b == batch {
+			return true
+		}
+	}
+	return false
+}
+
+// sseStream sends events as Server-Sent Events.
+type sseStream struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+	r  *http.Request
+}
+
+// newSSEStream starts the event stream response, or responds
+// with an error if w cannot stream. The server's timeouts no
+// longer apply to the request; each event gets its own write
+// deadline instead.
+func newSSEStream(w http.ResponseWriter, r *http.Request) (*sseStream, bool) {
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		respondErr(w, r, http.StatusInternalServerError, "streaming unsupported")
+		return nil, false
+	}
+	s := &sseStream{w: w, rc: rc, r: r}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// keep nginx from buffering the events
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := s.write("retry: %d\n\n", retryInterval/time.Millisecond); err != nil {
+		return nil, false
+	}
+	return s, true
+}
+
+// write writes and flushes the formatted text.
+func (s *sseStream) write(format string, args ...interface{}) error {
+	if err := s.rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, format, args...); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+func (s *sseStream) send(id, event string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return s.write("id: %s\nevent: %s\ndata: %s\n\n", id, event, b)
+}
+
+func (s *sseStream) ping() error {
+	return s.write(": ping\n\n")
+}
+
+func (s *sseStream) done() <-chan struct{} {
+	return s.r.Context().Done()
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"net/http"
+	"socialpoll/poll"
+	"time"
+)
+
+// maxTimelineBuckets limits the number of buckets
+// a single timeline request may ask for.
+const maxTimelineBuckets = 1500
+
+// timeline is the history of the results of a poll,
+// laid out for charts: Series holds, for every option,
+// the votes received in the bucket starting at the
+// corresponding entry of Times.
+type timeline struct {
+	Poll       string           `json:"poll"`
+	Resolution poll.Resolution  `json:"resolution"`
+	From       time.Time        `json:"from"`
+	To         time.Time        `json:"to"`
+	Times      []time.Time      `json:"times"`
+	Series     map[string][]int `json:"series"`
+}
+
+// handlePollTimeline serves GET /polls/{id}/timeline, taking
+// the resolution (minute, hour or day; hour by default) and the
+// from and to times (RFC 3339; by default, the last 60 buckets)
+// from the query.
+func (s *Server) handlePollTimeline(w http.ResponseWriter, r *http.Request) {
+	id := Param(r, "id")
+	if s.timeline == nil {
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	res := poll.Hour
+	if v := q.Get("resolution"); v != "" {
+		var err error
+		if res, err = poll.ParseResolution(v); err != nil {
+			respondInvalid(w, r, invalidParam("resolution", err))
+			return
+		}
+	}
+	to := time.Now().UTC()
+	if v := q.Get("to"); v != "" {
+		var err error
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			respondInvalid(w, r, invalidParam("to", err))
+			return
+		}
+	}
+	from := to.Add(-60 * res.Duration())
+	if v := q.Get("from"); v != "" {
+		var err error
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			respondInvalid(w, r, invalidParam("from", err))
+			return
+		}
+	}
+	from = from.UTC().Truncate(res.Duration())
+	if !from.Before(to) {
+		respondInvalid(w, r, &poll.FieldError{Field: "from", Code: poll.CodeInvalid, Message: "from must be before to"})
+		return
+	}
+	if to.Sub(from)/res.Duration() > maxTimelineBuckets {
+		respondInvalid(w, r, &poll.FieldError{Field: "resolution", Code: poll.CodeTooMany, Message: "too many buckets, use a coarser resolution"})
+		return
+	}
+
+	p, ok := s.ownedPoll(w, r, id)
+	if !ok {
+		return
+	}
+	buckets, err := s.timeline.Timeline(id, res, from, to)
+	if err != nil {
+		respondErr(w, r, http.StatusInternalServerError, "failed to load timeline", err)
+		return
+	}
+
+	result := &timeline{
+		Poll:       id,
+		Resolution: res,
+		From:       from,
+		To:         to.UTC(),
+		Series:     make(map[string][]int),
+	}
+	for t := from; t.Before(to); t = t.Add(res.Duration()) {
+		result.Times = append(result.Times, t)
+	}
+	series := func(option string) []int {
+		if result.Series[option] == nil {
+			result.Series[option] = make([]int, len(result.Times))
+		}
+		return result.Series[option]
+	}
+	for _, option := range p.Options {
+		series(option)
+	}
+	for _, b := range buckets {
+		i := int(b.Time.Sub(from) / res.Duration())
+		if i < 0 || i >= len(result.Times) {
+			continue
+		}
+		for option, count := range b.Counts {
+			series(option)[i] += count
+		}
+	}
+	respond(w, r, http.StatusOK, result)
+}
+
+// invalidParam describes the error parsing a query parameter.
+func invalidParam(name string, err error) *poll.FieldError {
+	return &poll.FieldError{Field: name, Code: poll.CodeInvalid, Message: err.Error()}
+}
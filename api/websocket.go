@@ -0,0 +1,219 @@
+package api
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is the GUID of RFC 6455, which the handshake
+// mixes in the key of the client.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes.
+const (
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xA
+)
+
+// maxClientFrame is the largest frame clients may send;
+// they have nothing to say beyond control frames.
+const maxClientFrame = 4096
+
+var errFrameTooLarge = errors.New("websocket frame too large")
+
+// isWebSocket reports whether the request asks to upgrade
+// to a WebSocket.
+func isWebSocket(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		headerHasToken(r.Header, "Connection", "upgrade")
+}
+
+// headerHasToken reports whether the comma separated
+// values of the header hold the token, ignoring case.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsStream sends events over a WebSocket, as JSON text
+// messages holding their ID, name and data. It only reads
+// control frames from the client; other messages are ignored.
+type wsStream struct {
+	conn   net.Conn
+	rw     *bufio.ReadWriter
+	lock   sync.Mutex // serializes writes
+	closed chan struct{}
+}
+
+// upgradeWebSocket completes the WebSocket handshake,
+// or responds with an error if the request is not a valid one.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsStream, bool) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		respondErr(w, r, http.StatusBadRequest, "unsupported WebSocket handshake")
+		return nil, false
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		respondErr(w, r, http.StatusInternalServerError, "WebSocket unsupported")
+		return nil, false
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		respondErr(w, r, http.StatusInternalServerError, "failed to upgrade", err)
+		return nil, false
+	}
+	// the server's timeouts no longer apply
+	conn.SetDeadline(time.Time{})
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, false
+	}
+	ws := &wsStream{conn: conn, rw: rw, closed: make(chan struct{})}
+	go ws.readLoop()
+	return ws, true
+}
+
+// wsEvent is the message carrying an event.
+type wsEvent struct {
+	ID    string      `json:"id"`
+	Event string      `json:"event"`
+	Data  interface{} `json:"data"`
+}
+
+func (ws *wsStream) send(id, event string, data interface{}) error {
+	b, err := json.Marshal(&wsEvent{ID: id, Event: event, Data: data})
+	if err != nil {
+		return err
+	}
+	return ws.writeFrame(opText, b)
+}
+
+func (ws *wsStream) ping() error {
+	return ws.writeFrame(opPing, nil)
+}
+
+func (ws *wsStream) done() <-chan struct{} {
+	return ws.closed
+}
+
+// close sends a close frame and closes the connection.
+func (ws *wsStream) close() {
+	ws.writeFrame(opClose, nil)
+	ws.conn.Close()
+}
+
+// writeFrame writes an unmasked, unfragmented frame,
+// as servers do.
+func (ws *wsStream) writeFrame(opcode byte, payload []byte) error {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+	header := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = append(header, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+	ws.conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	if _, err := ws.rw.Write(header); err != nil {
+		return err
+	}
+	if _, err := ws.rw.Write(payload); err != nil {
+		return err
+	}
+	return ws.rw.Flush()
+}
+
+// readLoop reads the frames of the client until it closes the
+// connection, answering pings, then closes ws.closed.
+func (ws *wsStream) readLoop() {
+	defer close(ws.closed)
+	for {
+		opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case opClose:
+			return
+		case opPing:
+			if err := ws.writeFrame(opPong, payload); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readFrame reads a frame of the client, unmasking its payload.
+func (ws *wsStream) readFrame() (opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(ws.rw, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode = header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	n := uint64(header[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if !masked {
+		return 0, nil, errors.New("unmasked websocket frame")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.rw, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	if n > maxClientFrame {
+		return 0, nil, errFrameTooLarge
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(ws.rw, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
@@ -0,0 +1,317 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"socialpoll/apikey"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsClient is the client end of a WebSocket.
+type wsClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// dialWebSocket sends the handshake for the path of the server,
+// with the headers given as name, value pairs, returning the
+// response and, if the upgrade succeeded, the client.
+func dialWebSocket(t *testing.T, srv *httptest.Server, path string, headers ...string) (*http.Response, *wsClient) {
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	r, _ := http.NewRequest("GET", srv.URL+path, nil)
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Connection", "keep-alive, Upgrade")
+	r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	r.Header.Set("Sec-WebSocket-Version", "13")
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	if err := r.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return resp, nil
+	}
+	return resp, &wsClient{t: t, conn: conn, br: br}
+}
+
+// writeFrame sends a masked frame, its length taking extLen
+// bytes after the header: 0 for lengths below 126, 2 or 8.
+func (c *wsClient) writeFrame(opcode byte, payload []byte, extLen int) {
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	var b bytes.Buffer
+	b.WriteByte(0x80 | opcode)
+	switch extLen {
+	case 0:
+		b.WriteByte(0x80 | byte(len(payload)))
+	case 2:
+		b.WriteByte(0x80 | 126)
+		binary.Write(&b, binary.BigEndian, uint16(len(payload)))
+	case 8:
+		b.WriteByte(0x80 | 127)
+		binary.Write(&b, binary.BigEndian, uint64(len(payload)))
+	}
+	b.Write(mask)
+	for i, p := range payload {
+		b.WriteByte(p ^ mask[i%4])
+	}
+	if _, err := c.conn.Write(b.Bytes()); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// readFrame reads a frame of the server, which must not be
+// masked.
+func (c *wsClient) readFrame() (opcode byte, payload []byte, err error) {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return readServerFrame(c.br)
+}
+
+func readServerFrame(r io.Reader) (opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	if header[0]&0x80 == 0 || header[1]&0x80 != 0 {
+		return 0, nil, errFrameTooLarge
+	}
+	n := uint64(header[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	payload = make([]byte, n)
+	_, err = io.ReadFull(r, payload)
+	return header[0] & 0x0F, payload, err
+}
+
+// readEvent reads the next event, skipping pings.
+func (c *wsClient) readEvent() *wsEvent {
+	for {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		if opcode == opPing {
+			continue
+		}
+		if opcode != opText {
+			c.t.Fatalf("expected a text frame, got opcode %d", opcode)
+		}
+		var e wsEvent
+		var data json.RawMessage
+		e.Data = &data
+		if err := json.Unmarshal(payload, &e); err != nil {
+			c.t.Fatal(err)
+		}
+		return &e
+	}
+}
+
+// expectClosed checks the server sends a close frame and
+// closes the connection.
+func (c *wsClient) expectClosed() {
+	opcode, _, err := c.readFrame()
+	if err != nil || opcode != opClose {
+		c.t.Fatalf("expected a close frame, got opcode %d, %v", opcode, err)
+	}
+	if _, _, err := c.readFrame(); err != io.EOF {
+		c.t.Fatalf("expected the connection to be closed, got %v", err)
+	}
+}
+
+// newStreamServer serves the API, returning the path of
+// the stream of a new poll, with the key.
+func newStreamServer(t *testing.T) (*testAPI, *httptest.Server, string) {
+	a := newTestAPI(t)
+	srv := httptest.NewServer(a.handler)
+	t.Cleanup(srv.Close)
+	key := a.mint(t, "alice", apikey.ScopePolls)
+	path := a.createTestPoll(t, key, "Editors", "vim", "emacs")
+	return a, srv, path + "/stream?key=" + key
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+	a, srv, path := newStreamServer(t)
+	resp, ws := dialWebSocket(t, srv, path)
+	if ws == nil {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	// the example of RFC 6455
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("unexpected Sec-WebSocket-Accept %q", accept)
+	}
+	e := ws.readEvent()
+	if e.Event != "results" || !strings.HasPrefix(e.ID, a.hub.prefix+"-") {
+		t.Errorf("expected the results first, got %+v", e)
+	}
+
+	tests := []struct {
+		name    string
+		headers []string
+		status  int
+	}{
+		{"old version", []string{"Sec-WebSocket-Version", "8"}, http.StatusBadRequest},
+		{"no key", []string{"Sec-WebSocket-Key", ""}, http.StatusBadRequest},
+		{"no key of the poll", []string{}, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		p := path
+		if test.status == http.StatusUnauthorized {
+			p = p[:strings.Index(p, "?")]
+		}
+		resp, ws := dialWebSocket(t, srv, p, test.headers...)
+		if ws != nil || resp.StatusCode != test.status {
+			t.Errorf("%s: expected %d, got %d", test.name, test.status, resp.StatusCode)
+		}
+		if test.status == http.StatusBadRequest && resp.Header.Get("Sec-WebSocket-Version") != "13" {
+			t.Errorf("%s: expected the supported version to be sent", test.name)
+		}
+	}
+}
+
+func TestWebSocketControlFrames(t *testing.T) {
+	_, srv, path := newStreamServer(t)
+	_, ws := dialWebSocket(t, srv, path)
+	ws.readEvent()
+
+	pingPong := func(payload string) {
+		ws.writeFrame(opPing, []byte(payload), 0)
+		opcode, got, err := ws.readFrame()
+		if err != nil || opcode != opPong || string(got) != payload {
+			t.Fatalf("expected pong %q, got opcode %d %q, %v", payload, opcode, got, err)
+		}
+	}
+	pingPong("")
+	pingPong("are you there?")
+	// messages of the client are read through and ignored,
+	// whatever the width of their length
+	ws.writeFrame(opText, bytes.Repeat([]byte("a"), 125), 0)
+	ws.writeFrame(opText, bytes.Repeat([]byte("b"), 300), 2)
+	ws.writeFrame(opText, bytes.Repeat([]byte("c"), maxClientFrame), 8)
+	ws.writeFrame(opText, []byte("short"), 8)
+	pingPong("still there?")
+
+	ws.writeFrame(opClose, []byte{0x03, 0xE8}, 0)
+	ws.expectClosed()
+}
+
+func TestWebSocketRejectsBadFrames(t *testing.T) {
+	_, srv, path := newStreamServer(t)
+	tests := []struct {
+		name  string
+		frame []byte
+	}{
+		{"unmasked", []byte{0x80 | opPing, 0}},
+		{"too large", append([]byte{0x80 | opText, 0x80 | 127}, 0, 0, 0, 0, 0, 0x10, 0, 0, 1, 2, 3, 4)},
+	}
+	for _, test := range tests {
+		_, ws := dialWebSocket(t, srv, path)
+		ws.readEvent()
+		ws.conn.Write(test.frame)
+		ws.expectClosed()
+	}
+}
+
+func TestWebSocketWritesFrameLengths(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	ws := &wsStream{conn: server, rw: bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server))}
+	for _, n := range []int{0, 125, 126, 0xFFFF, 0x10000, 0x12345} {
+		payload := bytes.Repeat([]byte{'x'}, n)
+		errc := make(chan error, 1)
+		go func() { errc <- ws.writeFrame(opText, payload) }()
+		opcode, got, err := readServerFrame(client)
+		if err != nil || opcode != opText || !bytes.Equal(got, payload) {
+			t.Errorf("length %d: got opcode %d, %d bytes, %v", n, opcode, len(got), err)
+		}
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWebSocketResume(t *testing.T) {
+	a, srv, path := newStreamServer(t)
+	id := path[len("/v1/polls/"):strings.Index(path, "/stream")]
+	_, ws := dialWebSocket(t, srv, path)
+	snapshot := ws.readEvent()
+	publishBatch(a.hub, "b1", "vim", id)
+	publishBatch(a.hub, "b2", "emacs", id)
+	first, second := ws.readEvent(), ws.readEvent()
+	if first.Event != "delta" || second.Event != "delta" {
+		t.Fatalf("expected deltas, got %s and %s", first.Event, second.Event)
+	}
+	var d delta
+	if err := json.Unmarshal(*second.Data.(*json.RawMessage), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Batch != "b2" || d.Counts["emacs"] != 1 {
+		t.Errorf("unexpected delta %+v", d)
+	}
+	ws.conn.Close()
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		event       string
+		batch       string
+	}{
+		{"from the results", snapshot.ID, "delta", "b1"},
+		{"from a delta", first.ID, "delta", "b2"},
+		{"from another process", "other-1", "results", ""},
+	}
+	for _, test := range tests {
+		_, ws := dialWebSocket(t, srv, path, "Last-Event-ID", test.lastEventID)
+		e := ws.readEvent()
+		if e.Event != test.event {
+			t.Errorf("%s: expected %s, got %s", test.name, test.event, e.Event)
+			continue
+		}
+		if test.batch != "" {
+			var d delta
+			json.Unmarshal(*e.Data.(*json.RawMessage), &d)
+			if d.Batch != test.batch {
+				t.Errorf("%s: expected delta of %s, got %s", test.name, test.batch, d.Batch)
+			}
+		}
+	}
+
+	// the deltas after the first are no longer all kept
+	for i := 0; i < replayEvents; i++ {
+		publishBatch(a.hub, "more", "vim", id)
+	}
+	_, ws = dialWebSocket(t, srv, path+"&lastEventId="+first.ID)
+	if e := ws.readEvent(); e.Event != "results" {
+		t.Errorf("too old: expected the results, got %s", e.Event)
+	}
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// xmlCodec encodes bodies as XML, following their JSON form:
+// objects become elements named after their fields, lists
+// repeat an <item> element, and maps, whose keys need not be
+// valid element names, hold an <entry key="..."> element per
+// key, in key order. The document element is <response>.
+type xmlCodec struct{}
+
+func (xmlCodec) Encode(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	if err := encodeXML(e, xml.StartElement{Name: xml.Name{Local: "response"}}, reflect.ValueOf(v)); err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+var (
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// encodeXML writes the element start holding v.
+func encodeXML(e *xml.Encoder, start xml.StartElement, v reflect.Value) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return e.EncodeElement("", start)
+		}
+		v = v.Elem()
+	}
+	if v.Type().Implements(textMarshalerType) {
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return err
+		}
+		return e.EncodeElement(string(text), start)
+	}
+	if v.Type().Implements(jsonMarshalerType) {
+		return errUnsupportedValue
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		if err := encodeXMLFields(e, v); err != nil {
+			return err
+		}
+		return e.EncodeToken(start.End())
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return errUnsupportedValue
+		}
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, k := range keys {
+			entry := xml.StartElement{
+				Name: xml.Name{Local: "entry"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: k.String()}},
+			}
+			if err := encodeXML(e, entry, v.MapIndex(k)); err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+	case reflect.Slice, reflect.Array:
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		item := xml.StartElement{Name: xml.Name{Local: "item"}}
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeXML(e, item, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return e.EncodeElement(fmt.Sprint(v.Interface()), start)
+	}
+	return errUnsupportedValue
+}
+
+// encodeXMLFields writes the fields of the struct as elements
+// named as in JSON, inlining embedded structs.
+func encodeXMLFields(e *xml.Encoder, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fv := v.Field(i)
+		name, omitEmpty := jsonField(f)
+		if name == "-" || (f.PkgPath != "" && !f.Anonymous) {
+			continue
+		}
+		if f.Anonymous && f.Tag.Get("json") == "" {
+			for fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					break
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				if err := encodeXMLFields(e, fv); err != nil {
+					return err
+				}
+				continue
+			}
+			if fv.Kind() == reflect.Ptr {
+				continue
+			}
+		}
+		if omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		if err := encodeXML(e, xml.StartElement{Name: xml.Name{Local: name}}, fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonField returns the JSON name of the field, or "-"
+// if it is left out of JSON, and whether it is omitted
+// when empty.
+func jsonField(f reflect.StructField) (name string, omitEmpty bool) {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "-", false
+	}
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "" {
+		name = f.Name
+	}
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return name, omitEmpty
+}
+
+// isEmptyValue reports whether v is empty as understood by
+// the omitempty option of JSON.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
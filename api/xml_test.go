@@ -0,0 +1,130 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"socialpoll/match"
+	"socialpoll/poll"
+	"strings"
+	"testing"
+	"time"
+)
+
+// xmlEntry is an <entry key="..."> element.
+type xmlEntry struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// xmlPoll reads a poll back from the XML codec.
+type xmlPoll struct {
+	ID      string     `xml:"id"`
+	Title   string     `xml:"title"`
+	Options []string   `xml:"options>item"`
+	Results []xmlEntry `xml:"results>entry"`
+	Match   []struct {
+		Key  string `xml:"key,attr"`
+		Mode string `xml:"mode"`
+	} `xml:"match>entry"`
+	Filter struct {
+		ExcludeRetweets bool `xml:"excludeRetweets"`
+	} `xml:"filter"`
+	Total    int       `xml:"total"`
+	Status   string    `xml:"status"`
+	OpensAt  time.Time `xml:"opensAt"`
+	ClosesAt *string   `xml:"closesAt"`
+	APIKey   *string   `xml:"APIKey"`
+	Archived *string   `xml:"archivedResults"`
+}
+
+func TestXMLRoundTrip(t *testing.T) {
+	opens := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	p := &poll.Poll{
+		ID:      bson.NewObjectId(),
+		Title:   "Tabs <or> spaces & more",
+		Options: []string{"tabs", "spaces"},
+		Results: map[string]int{"tabs": 3, "spaces": 5},
+		Match:   map[string]match.Rule{"tabs": {Mode: match.Hashtag}},
+		Filter:  poll.TweetFilter{ExcludeRetweets: true},
+		Total:   8,
+		Status:  poll.Open,
+		OpensAt: &opens,
+		APIKey:  "secret",
+	}
+	var buf bytes.Buffer
+	if err := (xmlCodec{}).Encode(&buf, onePoll{p}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), xml.Header) {
+		t.Errorf("missing XML header")
+	}
+	var doc struct {
+		XMLName xml.Name  `xml:"response"`
+		Items   []xmlPoll `xml:"item"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("%v in %s", err, buf.String())
+	}
+	if len(doc.Items) != 1 {
+		t.Fatalf("expected one item, got %d", len(doc.Items))
+	}
+	got := doc.Items[0]
+	if got.ID != p.ID.Hex() || got.Title != p.Title || got.Total != 8 || got.Status != "open" {
+		t.Errorf("fields differ: %+v", got)
+	}
+	if !reflect.DeepEqual(got.Options, p.Options) {
+		t.Errorf("expected options %v, got %v", p.Options, got.Options)
+	}
+	// map entries are in key order
+	want := []xmlEntry{{"spaces", "5"}, {"tabs", "3"}}
+	if !reflect.DeepEqual(got.Results, want) {
+		t.Errorf("expected results %v, got %v", want, got.Results)
+	}
+	if len(got.Match) != 1 || got.Match[0].Key != "tabs" || got.Match[0].Mode != "hashtag" {
+		t.Errorf("unexpected match rules %+v", got.Match)
+	}
+	if !got.Filter.ExcludeRetweets {
+		t.Errorf("filter lost")
+	}
+	if !got.OpensAt.Equal(opens) {
+		t.Errorf("expected opensAt %v, got %v", opens, got.OpensAt)
+	}
+	// omitempty and json:"-" fields are left out, as in JSON
+	if got.ClosesAt != nil || got.APIKey != nil || got.Archived != nil {
+		t.Errorf("expected omitted fields to be left out: %s", buf.String())
+	}
+}
+
+func TestXMLEncodesErrorsAndLists(t *testing.T) {
+	var buf bytes.Buffer
+	body := &errorBody{&apiError{Code: "invalid", Message: "bad", Fields: []*poll.FieldError{
+		{Field: "options[0]", Code: "too_long", Message: "long"},
+	}}}
+	if err := (xmlCodec{}).Encode(&buf, body); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Code   string `xml:"error>code"`
+		Fields []struct {
+			Field string `xml:"field"`
+			Code  string `xml:"code"`
+		} `xml:"error>fields>item"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Code != "invalid" || len(doc.Fields) != 1 || doc.Fields[0].Field != "options[0]" {
+		t.Errorf("unexpected error document %s", buf.String())
+	}
+}
+
+func TestXMLRejectsJSONMarshalers(t *testing.T) {
+	var buf bytes.Buffer
+	err := (xmlCodec{}).Encode(&buf, json.RawMessage(`{}`))
+	if err != errUnsupportedValue {
+		t.Errorf("expected errUnsupportedValue, got %v", err)
+	}
+}
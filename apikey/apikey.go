@@ -0,0 +1,119 @@
+// Package apikey keeps the keys clients call the API with.
+// Only a hash of each key is stored, so reading the store
+// does not give away working keys.
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+const (
+	// ScopePolls lets a key create and manage polls.
+	ScopePolls = "polls"
+	// ScopeAdmin lets a key do anything, including
+	// minting and revoking keys.
+	ScopeAdmin = "admin"
+)
+
+// ErrNotFound is returned for unknown keys.
+var ErrNotFound = errors.New("apikey: not found")
+
+// ValidScope reports whether s is a known scope.
+func ValidScope(s string) bool {
+	return s == ScopePolls || s == ScopeAdmin
+}
+
+// Key describes an API key. The key itself is only
+// known to whoever minted it; Hash identifies it.
+type Key struct {
+	// ID names the key publicly, for instance to revoke it.
+	ID      string     `json:"id" bson:"_id"`
+	Hash    string     `json:"-" bson:"hash"`
+	Owner   string     `json:"owner" bson:"owner"`
+	Scopes  []string   `json:"scopes" bson:"scopes"`
+	Created time.Time  `json:"created" bson:"created"`
+	Revoked *time.Time `json:"revoked,omitempty" bson:"revoked,omitempty"`
+	// Tier names the rate limits and quotas of the key;
+	// keys without one are in the default tier.
+	Tier string `json:"tier,omitempty" bson:"tier,omitempty"`
+}
+
+// HasScope reports whether the key grants scope.
+// Admin keys grant every scope.
+func (k *Key) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Active reports whether the key has not been revoked.
+func (k *Key) Active() bool {
+	return k.Revoked == nil
+}
+
+// Hash returns the hash a key is stored under.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Store keeps API keys.
+type Store interface {
+	// Create stores k, setting its ID.
+	Create(k *Key) error
+	// Find returns the key with the given hash.
+	Find(hash string) (*Key, error)
+	// List returns every key, including revoked ones.
+	List() ([]*Key, error)
+	// Revoke marks the key with the given ID as revoked
+	// at t. Revoking a revoked key does nothing.
+	Revoke(id string, t time.Time) error
+}
+
+// Mint creates a new random key for owner with the given
+// tier and scopes, returning the key, which is not stored
+// anywhere, along with its description.
+func Mint(s Store, owner, tier string, scopes []string) (string, *Key, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	key := base64.RawURLEncoding.EncodeToString(b)
+	k := &Key{
+		Hash:    Hash(key),
+		Owner:   owner,
+		Scopes:  scopes,
+		Created: time.Now().UTC(),
+		Tier:    tier,
+	}
+	if err := s.Create(k); err != nil {
+		return "", nil, err
+	}
+	return key, k, nil
+}
+
+// Bootstrap mints an admin key if s holds no keys at all,
+// so that a fresh install can be used. It returns "" if
+// there already are keys.
+func Bootstrap(s Store) (string, error) {
+	keys, err := s.List()
+	if err != nil || len(keys) > 0 {
+		return "", err
+	}
+	key, _, err := Mint(s, "admin", "", []string{ScopeAdmin})
+	return key, err
+}
+
+// newID returns the ID of a new key.
+func newID() string {
+	return bson.NewObjectId().Hex()
+}
@@ -0,0 +1,81 @@
+package apikey
+
+import (
+	"sync"
+	"time"
+)
+
+// maxCached bounds how many keys a Cache remembers, so
+// requests with made up keys cannot fill the memory.
+const maxCached = 10000
+
+// Cache is a Store remembering the keys looked up for a
+// while, so that checking the key of every request does
+// not hit the underlying store.
+// Keys revoked through the Cache stop working at once;
+// keys revoked elsewhere keep working for up to the TTL.
+type Cache struct {
+	Store
+	ttl time.Duration
+
+	lock    sync.Mutex // protects entries
+	entries map[string]cacheEntry
+}
+
+// cacheEntry is a looked up key, which is nil for
+// unknown keys.
+type cacheEntry struct {
+	key     *Key
+	expires time.Time
+}
+
+// NewCache creates a Cache over s remembering keys for ttl.
+func NewCache(s Store, ttl time.Duration) *Cache {
+	return &Cache{
+		Store:   s,
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+// Lookup returns the active key matching key, or
+// ErrNotFound if it is unknown or revoked.
+func (c *Cache) Lookup(key string) (*Key, error) {
+	hash := Hash(key)
+	now := time.Now()
+	c.lock.Lock()
+	e, ok := c.entries[hash]
+	c.lock.Unlock()
+	if !ok || now.After(e.expires) {
+		k, err := c.Store.Find(hash)
+		if err != nil && err != ErrNotFound {
+			return nil, err
+		}
+		e = cacheEntry{key: k, expires: now.Add(c.ttl)}
+		c.lock.Lock()
+		if len(c.entries) >= maxCached {
+			c.entries = make(map[string]cacheEntry)
+		}
+		c.entries[hash] = e
+		c.lock.Unlock()
+	}
+	if e.key == nil || !e.key.Active() {
+		return nil, ErrNotFound
+	}
+	return e.key, nil
+}
+
+// Revoke revokes the key and forgets it.
+func (c *Cache) Revoke(id string, t time.Time) error {
+	if err := c.Store.Revoke(id, t); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for hash, e := range c.entries {
+		if e.key != nil && e.key.ID == id {
+			delete(c.entries, hash)
+		}
+	}
+	return nil
+}
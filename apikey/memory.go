@@ -0,0 +1,76 @@
+package apikey
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store keeping keys in memory.
+// It is safe for use by many goroutines, but forgets
+// every key when the process exits.
+type MemoryStore struct {
+	lock sync.RWMutex // protects keys
+	keys map[string]*Key
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keys: make(map[string]*Key)}
+}
+
+// clone copies k, so callers cannot change stored keys.
+func clone(k *Key) *Key {
+	c := *k
+	c.Scopes = append([]string(nil), k.Scopes...)
+	if k.Revoked != nil {
+		t := *k.Revoked
+		c.Revoked = &t
+	}
+	return &c
+}
+
+func (s *MemoryStore) Create(k *Key) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	k.ID = newID()
+	s.keys[k.ID] = clone(k)
+	return nil
+}
+
+func (s *MemoryStore) Find(hash string) (*Key, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, k := range s.keys {
+		if k.Hash == hash {
+			return clone(k), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryStore) List() ([]*Key, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	result := make([]*Key, 0, len(s.keys))
+	for _, k := range s.keys {
+		result = append(result, clone(k))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Created.Before(result[j].Created)
+	})
+	return result, nil
+}
+
+func (s *MemoryStore) Revoke(id string, t time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if k.Revoked == nil {
+		k.Revoked = &t
+	}
+	return nil
+}
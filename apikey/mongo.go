@@ -0,0 +1,91 @@
+package apikey
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+// MongoStore is a Store keeping keys in a MongoDB collection.
+// Each call works on its own copy of the session, so a
+// MongoStore may be used by many goroutines.
+type MongoStore struct {
+	session    *mgo.Session
+	database   string
+	collection string
+}
+
+// NewMongoStore creates a Store keeping keys in the named
+// database and collection of the session.
+func NewMongoStore(session *mgo.Session, database, collection string) *MongoStore {
+	return &MongoStore{
+		session:    session,
+		database:   database,
+		collection: collection,
+	}
+}
+
+// keys returns the collection along with a function
+// closing the session it uses.
+func (s *MongoStore) keys() (*mgo.Collection, func()) {
+	session := s.session.Copy()
+	return session.DB(s.database).C(s.collection), session.Close
+}
+
+// EnsureIndexes creates the index keys are looked up by.
+func (s *MongoStore) EnsureIndexes() error {
+	c, done := s.keys()
+	defer done()
+	return c.EnsureIndex(mgo.Index{Key: []string{"hash"}, Unique: true})
+}
+
+func (s *MongoStore) Create(k *Key) error {
+	c, done := s.keys()
+	defer done()
+	k.ID = newID()
+	return c.Insert(k)
+}
+
+func (s *MongoStore) Find(hash string) (*Key, error) {
+	c, done := s.keys()
+	defer done()
+	var k Key
+	if err := c.Find(bson.M{"hash": hash}).One(&k); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (s *MongoStore) List() ([]*Key, error) {
+	c, done := s.keys()
+	defer done()
+	var result []*Key
+	if err := c.Find(nil).Sort("created").All(&result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *MongoStore) Revoke(id string, t time.Time) error {
+	c, done := s.keys()
+	defer done()
+	err := c.Update(
+		bson.M{"_id": id, "revoked": nil},
+		bson.M{"$set": bson.M{"revoked": t}},
+	)
+	if err != mgo.ErrNotFound {
+		return err
+	}
+	// either unknown or already revoked
+	n, err := c.FindId(id).Count()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
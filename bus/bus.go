@@ -0,0 +1,55 @@
+// Package bus carries messages, such as votes, between the
+// socialpoll commands.
+//
+// Messages are published on topics. Every channel of a topic
+// receives a copy of each message, which is handed to one of
+// the channel's consumers.
+package bus
+
+// Bus creates publishers and consumers for a message transport.
+type Bus interface {
+	// NewPublisher creates a Publisher.
+	NewPublisher() (Publisher, error)
+	// NewConsumer creates a Consumer of the channel of the
+	// topic, handing every message to the handler.
+	NewConsumer(topic, channel string, handler Handler) (Consumer, error)
+}
+
+// Publisher publishes messages.
+type Publisher interface {
+	// Publish publishes body on the topic.
+	Publish(topic string, body []byte) error
+	// Stop stops the publisher.
+	Stop()
+}
+
+// Consumer receives the messages of a channel.
+type Consumer interface {
+	// Stop asks the consumer to stop receiving messages.
+	Stop()
+	// Stopped is closed once the consumer has stopped.
+	Stopped() <-chan struct{}
+}
+
+// Message is a message delivered to a consumer.
+type Message interface {
+	// Body returns the content of the message.
+	Body() []byte
+	// DisableAutoResponse keeps the message from being finished
+	// or requeued when its handler returns, so the handler can
+	// decide later by calling Finish or Requeue itself.
+	DisableAutoResponse()
+	// Finish acknowledges the message, which will not be
+	// delivered again.
+	Finish()
+	// Requeue asks for the message to be delivered again later.
+	Requeue()
+	// Touch tells the transport the message is still being
+	// worked on, so it is not redelivered in the meantime.
+	Touch()
+}
+
+// Handler handles a message. Unless its auto response is
+// disabled, the message is finished when the handler returns
+// nil, and requeued when it returns an error.
+type Handler func(m Message) error
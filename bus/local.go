@@ -0,0 +1,256 @@
+package bus
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrStopped is returned when publishing
+// with a stopped Publisher.
+var ErrStopped = errors.New("bus: stopped")
+
+// requeueDelay is how long a message whose handler
+// failed waits before being delivered again.
+const requeueDelay = 1 * time.Second
+
+// Local is a Bus passing messages between goroutines
+// of a single process, for development and demos.
+// The zero value is ready to use.
+type Local struct {
+	lock   sync.Mutex // protects topics
+	topics map[string]*localTopic
+}
+
+// localTopic holds the channels of a topic.
+// Messages published before any channel exists are
+// kept for the first one, as nsqd does.
+type localTopic struct {
+	channels map[string]*localChannel
+	pending  [][]byte
+}
+
+// localChannel holds the messages of a channel until its
+// consumer reads them. Once the consumer stops, messages are
+// only kept while the buffer has room, so publishers never
+// wait for a consumer that is gone.
+type localChannel struct {
+	ch   chan []byte
+	stop chan struct{} // closed when the latest consumer stops
+}
+
+// send queues body on ch, waiting for room unless stop or done
+// is closed first, in which case it returns ErrStopped.
+func send(ch chan<- []byte, body []byte, stop, done <-chan struct{}) error {
+	select {
+	case ch <- body:
+		return nil
+	default:
+	}
+	select {
+	case ch <- body:
+		return nil
+	case <-stop:
+		return ErrStopped
+	case <-done:
+		return ErrStopped
+	}
+}
+
+func (b *Local) topic(name string) *localTopic {
+	if b.topics == nil {
+		b.topics = make(map[string]*localTopic)
+	}
+	t, ok := b.topics[name]
+	if !ok {
+		t = &localTopic{channels: make(map[string]*localChannel)}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// publish sends body to every channel of the topic, until
+// done is closed.
+func (b *Local) publish(topic string, body []byte, done <-chan struct{}) error {
+	b.lock.Lock()
+	t := b.topic(topic)
+	if len(t.channels) == 0 {
+		t.pending = append(t.pending, body)
+		b.lock.Unlock()
+		return nil
+	}
+	channels := make([]localChannel, 0, len(t.channels))
+	for _, c := range t.channels {
+		channels = append(channels, *c)
+	}
+	b.lock.Unlock()
+	var err error
+	for _, c := range channels {
+		if e := send(c.ch, body, c.stop, done); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (b *Local) NewPublisher() (Publisher, error) {
+	return &localPublisher{bus: b, done: make(chan struct{})}, nil
+}
+
+func (b *Local) NewConsumer(topic, channel string, handler Handler) (Consumer, error) {
+	b.lock.Lock()
+	t := b.topic(topic)
+	lc, ok := t.channels[channel]
+	if !ok {
+		lc = &localChannel{ch: make(chan []byte, 1024)}
+		t.channels[channel] = lc
+	}
+	// the channel has a consumer again
+	lc.stop = make(chan struct{})
+	pending := t.pending
+	t.pending = nil
+	b.lock.Unlock()
+
+	c := &localConsumer{
+		ch:       lc.ch,
+		handler:  handler,
+		stopChan: lc.stop,
+		stopped:  make(chan struct{}),
+	}
+	go func() {
+		for _, body := range pending {
+			if send(c.ch, body, c.stopChan, nil) != nil {
+				log.Println("bus: dropping message for stopped consumer")
+			}
+		}
+	}()
+	go c.run()
+	return c, nil
+}
+
+type localPublisher struct {
+	bus      *Local
+	done     chan struct{} // closed by Stop
+	stopOnce sync.Once
+}
+
+// Publish returns ErrStopped once the publisher is stopped, or
+// if it gave up waiting for room in a channel whose consumer
+// stopped.
+func (p *localPublisher) Publish(topic string, body []byte) error {
+	select {
+	case <-p.done:
+		return ErrStopped
+	default:
+	}
+	return p.bus.publish(topic, body, p.done)
+}
+
+func (p *localPublisher) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.done)
+	})
+}
+
+type localConsumer struct {
+	ch       chan []byte
+	handler  Handler
+	stopOnce sync.Once
+	stopChan chan struct{}
+	stopped  chan struct{}
+}
+
+func (c *localConsumer) run() {
+	defer close(c.stopped)
+	for {
+		select {
+		case <-c.stopChan:
+			return
+		case body := <-c.ch:
+			m := &localMessage{body: body, ch: c.ch, stop: c.stopChan}
+			err := c.handler(m)
+			if !m.autoResponseDisabled() {
+				if err != nil {
+					m.Requeue()
+				} else {
+					m.Finish()
+				}
+			}
+		}
+	}
+}
+
+func (c *localConsumer) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopChan)
+	})
+}
+
+func (c *localConsumer) Stopped() <-chan struct{} {
+	return c.stopped
+}
+
+// localMessage is a Message delivered by a Local bus.
+type localMessage struct {
+	body []byte
+	ch   chan []byte
+	stop <-chan struct{} // closed when the consumer stops
+
+	lock      sync.Mutex // protects the fields below
+	autoOff   bool
+	responded bool
+}
+
+func (m *localMessage) Body() []byte {
+	return m.body
+}
+
+func (m *localMessage) DisableAutoResponse() {
+	m.lock.Lock()
+	m.autoOff = true
+	m.lock.Unlock()
+}
+
+func (m *localMessage) autoResponseDisabled() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.autoOff
+}
+
+// respond marks the message as responded to, reporting
+// whether it was not already.
+func (m *localMessage) respond() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.responded {
+		return false
+	}
+	m.responded = true
+	return true
+}
+
+func (m *localMessage) Finish() {
+	m.respond()
+}
+
+func (m *localMessage) Requeue() {
+	if !m.respond() {
+		return
+	}
+	// deliver it again later, without holding
+	// up the rest of the channel; once the consumer
+	// stops, it is only kept if there is room
+	go func() {
+		select {
+		case <-time.After(requeueDelay):
+		case <-m.stop:
+		}
+		if send(m.ch, m.body, m.stop, nil) != nil {
+			log.Println("bus: dropping requeued message of stopped consumer")
+		}
+	}()
+}
+
+// Touch does nothing, as local messages never time out.
+func (m *localMessage) Touch() {}
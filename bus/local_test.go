@@ -0,0 +1,176 @@
+package bus
+
+import (
+	"testing"
+	"time"
+)
+
+// within fails the test unless fn returns within a second.
+func within(t *testing.T, what string, fn func()) {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("%s did not return", what)
+	}
+}
+
+func TestLocalDeliversToEveryChannel(t *testing.T) {
+	b := &Local{}
+	got := make(chan string, 2)
+	for _, channel := range []string{"a", "b"} {
+		channel := channel
+		q, err := b.NewConsumer("topic", channel, func(m Message) error {
+			got <- channel + ":" + string(m.Body())
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer q.Stop()
+	}
+	pub, err := b.NewPublisher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pub.Publish("topic", []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case s := <-got:
+			seen[s] = true
+		case <-time.After(time.Second):
+			t.Fatal("message not delivered")
+		}
+	}
+	if !seen["a:hi"] || !seen["b:hi"] {
+		t.Errorf("expected the message on both channels, got %v", seen)
+	}
+}
+
+func TestLocalKeepsMessagesForFirstConsumer(t *testing.T) {
+	b := &Local{}
+	pub, _ := b.NewPublisher()
+	if err := pub.Publish("topic", []byte("early")); err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan string, 1)
+	q, err := b.NewConsumer("topic", "c", func(m Message) error {
+		got <- string(m.Body())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Stop()
+	select {
+	case s := <-got:
+		if s != "early" {
+			t.Errorf("expected early, got %q", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not delivered")
+	}
+}
+
+// stoppedConsumer returns a bus whose channel has a consumer
+// that stopped after taking one message, and that message.
+func stoppedConsumer(t *testing.T) (*Local, Message) {
+	b := &Local{}
+	held := make(chan Message, 1)
+	q, err := b.NewConsumer("topic", "c", func(m Message) error {
+		m.DisableAutoResponse()
+		held <- m
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := b.NewPublisher()
+	if err := pub.Publish("topic", []byte("held")); err != nil {
+		t.Fatal(err)
+	}
+	m := <-held
+	q.Stop()
+	<-q.Stopped()
+	return b, m
+}
+
+func TestLocalPublishDoesNotWaitForStoppedConsumer(t *testing.T) {
+	b, _ := stoppedConsumer(t)
+	pub, _ := b.NewPublisher()
+	var err error
+	within(t, "Publish", func() {
+		for i := 0; i < 2000 && err == nil; i++ {
+			err = pub.Publish("topic", []byte("vote"))
+		}
+	})
+	if err != ErrStopped {
+		t.Errorf("expected ErrStopped once the buffer is full, got %v", err)
+	}
+}
+
+func TestLocalStopUnblocksPublish(t *testing.T) {
+	b := &Local{}
+	block := make(chan struct{})
+	defer close(block)
+	q, err := b.NewConsumer("topic", "c", func(m Message) error {
+		<-block
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Stop()
+	pub, _ := b.NewPublisher()
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			if err := pub.Publish("topic", []byte("vote")); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	// let the buffer fill up
+	time.Sleep(50 * time.Millisecond)
+	within(t, "Stop", pub.Stop)
+	select {
+	case err := <-errc:
+		if err != ErrStopped {
+			t.Errorf("expected ErrStopped, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Publish still blocked after Stop")
+	}
+}
+
+func TestLocalRequeueAfterStopKeepsMessage(t *testing.T) {
+	b, m := stoppedConsumer(t)
+	// the consumer is gone, so the message goes back to the
+	// channel at once, for the next consumer
+	m.Requeue()
+	got := make(chan string, 1)
+	q, err := b.NewConsumer("topic", "c", func(m Message) error {
+		got <- string(m.Body())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Stop()
+	select {
+	case s := <-got:
+		if s != "held" {
+			t.Errorf("expected the requeued message, got %q", s)
+		}
+	case <-time.After(requeueDelay / 2):
+		t.Fatal("requeued message not delivered to the next consumer")
+	}
+}
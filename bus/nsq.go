@@ -0,0 +1,70 @@
+package bus
+
+import "github.com/bitly/go-nsq"
+
+// NSQ is a Bus using NSQ daemons.
+type NSQ struct {
+	// Nsqd is the TCP address of the nsqd daemon
+	// messages are published to.
+	Nsqd string
+	// Lookupd is the HTTP address of the nsqlookupd
+	// daemon consumers find publishers with.
+	Lookupd string
+	// MaxInFlight is how many messages a consumer may hold
+	// without responding to them; it defaults to 1.
+	MaxInFlight int
+}
+
+func (b *NSQ) NewPublisher() (Publisher, error) {
+	pub, err := nsq.NewProducer(b.Nsqd, nsq.NewConfig())
+	if err != nil {
+		return nil, err
+	}
+	return pub, nil
+}
+
+func (b *NSQ) NewConsumer(topic, channel string, handler Handler) (Consumer, error) {
+	config := nsq.NewConfig()
+	if b.MaxInFlight > 0 {
+		config.MaxInFlight = b.MaxInFlight
+	}
+	q, err := nsq.NewConsumer(topic, channel, config)
+	if err != nil {
+		return nil, err
+	}
+	q.AddHandler(nsq.HandlerFunc(func(m *nsq.Message) error {
+		return handler(nsqMessage{m})
+	}))
+	if err := q.ConnectToNSQLookupd(b.Lookupd); err != nil {
+		return nil, err
+	}
+	c := &nsqConsumer{Consumer: q, stopped: make(chan struct{})}
+	go func() {
+		<-q.StopChan
+		close(c.stopped)
+	}()
+	return c, nil
+}
+
+type nsqConsumer struct {
+	*nsq.Consumer
+	stopped chan struct{}
+}
+
+func (c *nsqConsumer) Stopped() <-chan struct{} {
+	return c.stopped
+}
+
+// nsqMessage adapts an nsq.Message to the Message interface.
+type nsqMessage struct {
+	*nsq.Message
+}
+
+func (m nsqMessage) Body() []byte {
+	return m.Message.Body
+}
+
+func (m nsqMessage) Requeue() {
+	// a delay of -1 backs off based on the number of attempts
+	m.Message.Requeue(-1)
+}
@@ -0,0 +1,181 @@
+// Command allinone runs twittervotes, counter and the API
+// in a single process, passing votes over an in-process
+// bus instead of NSQ, for development.
+package main
+
+import (
+	"flag"
+	"gopkg.in/mgo.v2"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"socialpoll/api"
+	"socialpoll/apikey"
+	"socialpoll/bus"
+	"socialpoll/config"
+	"socialpoll/counter"
+	"socialpoll/dedup"
+	"socialpoll/poll"
+	"socialpoll/ratelimit"
+	"socialpoll/twittervotes"
+	"sync"
+	"syscall"
+)
+
+func main() {
+	conf := config.Register(flag.CommandLine)
+	conf.Alias(flag.CommandLine, "sources", "votes.sources")
+	conf.Alias(flag.CommandLine, "replay", "votes.replay_file")
+	conf.Alias(flag.CommandLine, "replay-speed", "votes.replay_speed")
+	conf.Alias(flag.CommandLine, "replay-loop", "votes.replay_loop")
+	flag.Parse()
+	cfg, err := conf.Load()
+	if err != nil {
+		log.Fatalln("failed to load config:", err)
+	}
+
+	tracker := &twittervotes.Tracker{}
+	var timeline poll.TimelineStore
+	var keys apikey.Store
+	var db *mgo.Session
+	if cfg.Store.Backend == "memory" {
+		log.Println("Keeping polls in memory")
+		tracker.Polls = poll.NewMemoryStore()
+		tracker.Voters = dedup.NewMemoryStore()
+		timeline = poll.NewMemoryTimelineStore()
+		keys = apikey.NewMemoryStore()
+	} else {
+		log.Println("Dialing mongo", cfg.Mongo.URI)
+		db, err = mgo.Dial(cfg.Mongo.URI)
+		if err != nil {
+			log.Fatalln("failed to connect to mongo:", err)
+		}
+		defer db.Close()
+		store := poll.NewMongoStore(db, cfg.Mongo.Database, cfg.Mongo.Polls)
+		if err := store.EnsureIndexes(); err != nil {
+			log.Fatalln("failed to create indexes:", err)
+		}
+		tracker.Polls = store
+		timelineStore := poll.NewMongoTimelineStore(db, cfg.Mongo.Database, cfg.Mongo.Timeline)
+		if err := timelineStore.EnsureIndexes(); err != nil {
+			log.Fatalln("failed to create indexes:", err)
+		}
+		timeline = timelineStore
+		tracker.Voters = dedup.NewMongoStore(db.DB(cfg.Mongo.Database).C(cfg.Mongo.Voters))
+		keyStore := apikey.NewMongoStore(db, cfg.Mongo.Database, cfg.Mongo.Keys)
+		if err := keyStore.EnsureIndexes(); err != nil {
+			log.Fatalln("failed to create indexes:", err)
+		}
+		keys = keyStore
+	}
+	key, err := apikey.Bootstrap(keys)
+	if err != nil {
+		log.Fatalln("failed to create admin key:", err)
+	}
+	if key != "" {
+		log.Println("No API keys yet; created admin key", key)
+	}
+
+	// graceful shutdown on system signals
+	stopChan := make(chan struct{})
+	signalChan := make(chan os.Signal, 1)
+	go func() {
+		<-signalChan
+		log.Println("Stopping...")
+		close(stopChan)
+	}()
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+
+	b := &bus.Local{}
+	var wg sync.WaitGroup
+
+	// counter announces results with its own publisher, as
+	// twittervotes stops its publisher when done
+	updates, err := b.NewPublisher()
+	if err != nil {
+		log.Fatalln("failed to create publisher:", err)
+	}
+	c := counter.New(tracker.Polls, timeline)
+	c.PublishUpdates(updates, cfg.NSQ.ResultsTopic)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := c.Run(b, cfg.NSQ.Topic, cfg.NSQ.Channel, cfg.Counter.FlushInterval, stopChan)
+		if err != nil {
+			log.Fatalln("counter:", err)
+		}
+	}()
+
+	pub, err := b.NewPublisher()
+	if err != nil {
+		log.Fatalln("failed to create publisher:", err)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := twittervotes.Run(voteOptions(cfg), tracker, pub, cfg.NSQ.Topic, stopChan); err != nil {
+			log.Fatalln("twittervotes:", err)
+		}
+	}()
+
+	if cfg.Counter.MetricsAddr != "" {
+		go func() {
+			log.Println("Serving metrics on", cfg.Counter.MetricsAddr)
+			// expvar serves /debug/vars on the default mux
+			log.Println(http.ListenAndServe(cfg.Counter.MetricsAddr, nil))
+		}()
+	}
+
+	s := api.NewServer(tracker.Polls, timeline, apikey.NewCache(keys, cfg.API.KeyCacheTTL))
+	s.SetLimits(cfg.API.Tiers, ratelimit.Limit{Rate: cfg.API.IPRate, Burst: cfg.API.IPBurst})
+	s.SetCORS(api.CORS{
+		Origins:     cfg.API.CORSOrigins,
+		Credentials: cfg.API.CORSCredentials,
+		MaxAge:      cfg.API.CORSMaxAge,
+	})
+	if _, err := s.Subscribe(b, cfg.NSQ.ResultsTopic, "api"); err != nil {
+		log.Fatalln("failed to subscribe to results:", err)
+	}
+	if db != nil {
+		s.AddCheck("mongo", pingMongo(db))
+	}
+	srv := &http.Server{
+		Addr:         cfg.API.Addr,
+		Handler:      s.Handler(),
+		ReadTimeout:  cfg.API.ReadTimeout,
+		WriteTimeout: cfg.API.WriteTimeout,
+		IdleTimeout:  cfg.API.IdleTimeout,
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		log.Println("Starting web service on", cfg.API.Addr)
+		if err := s.Serve(srv, stopChan, cfg.API.DrainDelay, cfg.API.ShutdownTimeout); err != nil {
+			log.Fatalln("api:", err)
+		}
+	}()
+
+	wg.Wait()
+}
+
+// pingMongo returns the health check of the MongoDB session.
+func pingMongo(db *mgo.Session) api.Check {
+	return func() error {
+		session := db.Copy()
+		defer session.Close()
+		return session.Ping()
+	}
+}
+
+// voteOptions returns the settings of the vote sources.
+func voteOptions(cfg *config.Config) twittervotes.Options {
+	return twittervotes.Options{
+		Sources: cfg.Votes.Sources,
+		Replay: twittervotes.ReplayOptions{
+			File:  cfg.Votes.ReplayFile,
+			Speed: cfg.Votes.ReplaySpeed,
+			Loop:  cfg.Votes.ReplayLoop,
+		},
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"socialpoll/api"
+	"socialpoll/apikey"
+	"socialpoll/bus"
+	"socialpoll/config"
+	"socialpoll/poll"
+	"socialpoll/ratelimit"
+	"syscall"
+)
+
+func main() {
+	conf := config.Register(flag.CommandLine)
+	conf.Alias(flag.CommandLine, "addr", "api.addr")
+	conf.Alias(flag.CommandLine, "mongo", "mongo.uri")
+	flag.Parse()
+	cfg, err := conf.Load()
+	if err != nil {
+		log.Fatalln("failed to load config:", err)
+	}
+
+	var polls poll.Store
+	var timeline poll.TimelineStore
+	var keys apikey.Store
+	var db *mgo.Session
+	if cfg.Store.Backend == "memory" {
+		log.Println("Keeping polls in memory")
+		polls = poll.NewMemoryStore()
+		timeline = poll.NewMemoryTimelineStore()
+		keys = apikey.NewMemoryStore()
+	} else {
+		log.Println("Dialing mongo", cfg.Mongo.URI)
+		db, err = mgo.Dial(cfg.Mongo.URI)
+		if err != nil {
+			log.Fatalln("failed to connect to mongo:", err)
+		}
+		defer db.Close()
+		polls = poll.NewMongoStore(db, cfg.Mongo.Database, cfg.Mongo.Polls)
+		timeline = poll.NewMongoTimelineStore(db, cfg.Mongo.Database, cfg.Mongo.Timeline)
+		keyStore := apikey.NewMongoStore(db, cfg.Mongo.Database, cfg.Mongo.Keys)
+		if err := keyStore.EnsureIndexes(); err != nil {
+			log.Fatalln("failed to create indexes:", err)
+		}
+		keys = keyStore
+	}
+	// minting keys here would log them, and each replica
+	// starting at once would mint its own
+	if cfg.API.BootstrapKey {
+		key, err := apikey.Bootstrap(keys)
+		if err != nil {
+			log.Fatalln("failed to create admin key:", err)
+		}
+		if key != "" {
+			log.Println("No API keys yet; created admin key", key)
+		}
+	} else if existing, err := keys.List(); err == nil && len(existing) == 0 {
+		log.Println("No API keys yet; mint one with: apikey mint -owner admin -scopes admin")
+	}
+
+	s := api.NewServer(polls, timeline, apikey.NewCache(keys, cfg.API.KeyCacheTTL))
+	s.SetLimits(cfg.API.Tiers, ratelimit.Limit{Rate: cfg.API.IPRate, Burst: cfg.API.IPBurst})
+	s.SetCORS(api.CORS{
+		Origins:     cfg.API.CORSOrigins,
+		Credentials: cfg.API.CORSCredentials,
+		MaxAge:      cfg.API.CORSMaxAge,
+	})
+	if db != nil {
+		s.AddCheck("mongo", pingMongo(db))
+	}
+	// every API process needs every update, so each one
+	// reads its own channel, gone once it disconnects
+	b := &bus.NSQ{Nsqd: cfg.NSQ.Nsqd, Lookupd: cfg.NSQ.Lookupd}
+	updates, err := s.Subscribe(b, cfg.NSQ.ResultsTopic, "api-"+bson.NewObjectId().Hex()+"#ephemeral")
+	if err != nil {
+		log.Fatalln("failed to subscribe to results:", err)
+	}
+	defer updates.Stop()
+
+	// drain requests on system signals
+	stopChan := make(chan struct{})
+	signalChan := make(chan os.Signal, 1)
+	go func() {
+		<-signalChan
+		log.Println("Stopping...")
+		close(stopChan)
+	}()
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+
+	srv := &http.Server{
+		Addr:         cfg.API.Addr,
+		Handler:      s.Handler(),
+		ReadTimeout:  cfg.API.ReadTimeout,
+		WriteTimeout: cfg.API.WriteTimeout,
+		IdleTimeout:  cfg.API.IdleTimeout,
+	}
+	log.Println("Starting web service on", cfg.API.Addr)
+	if err := s.Serve(srv, stopChan, cfg.API.DrainDelay, cfg.API.ShutdownTimeout); err != nil {
+		log.Fatalln("api:", err)
+	}
+	log.Println("Stopped")
+}
+
+// pingMongo returns the health check of the MongoDB session.
+func pingMongo(db *mgo.Session) api.Check {
+	return func() error {
+		session := db.Copy()
+		defer session.Close()
+		return session.Ping()
+	}
+}
@@ -0,0 +1,120 @@
+// Command apikey mints, lists and revokes the API keys
+// kept in MongoDB:
+//
+//	apikey mint -owner alice [-scopes polls,admin] [-tier name]
+//	apikey list
+//	apikey revoke <id>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gopkg.in/mgo.v2"
+	"log"
+	"os"
+	"socialpoll/apikey"
+	"socialpoll/config"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: apikey [flags] mint -owner name [-scopes polls,admin] [-tier name]")
+	fmt.Fprintln(os.Stderr, "       apikey [flags] list")
+	fmt.Fprintln(os.Stderr, "       apikey [flags] revoke id")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	conf := config.Register(flag.CommandLine)
+	flag.Usage = usage
+	flag.Parse()
+	cfg, err := conf.Load()
+	if err != nil {
+		log.Fatalln("failed to load config:", err)
+	}
+	if flag.NArg() == 0 {
+		usage()
+	}
+
+	db, err := mgo.Dial(cfg.Mongo.URI)
+	if err != nil {
+		log.Fatalln("failed to connect to mongo:", err)
+	}
+	defer db.Close()
+	keys := apikey.NewMongoStore(db, cfg.Mongo.Database, cfg.Mongo.Keys)
+	if err := keys.EnsureIndexes(); err != nil {
+		log.Fatalln("failed to create indexes:", err)
+	}
+
+	args := flag.Args()[1:]
+	switch flag.Arg(0) {
+	case "mint":
+		err = mint(keys, cfg.API.Tiers, args)
+	case "list":
+		err = list(keys)
+	case "revoke":
+		if len(args) != 1 {
+			usage()
+		}
+		err = keys.Revoke(args[0], time.Now().UTC())
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// mint creates a key and prints it; it cannot be
+// shown again.
+func mint(keys apikey.Store, tiers map[string]config.Tier, args []string) error {
+	fs := flag.NewFlagSet("mint", flag.ExitOnError)
+	owner := fs.String("owner", "", "owner of the key")
+	scopes := fs.String("scopes", apikey.ScopePolls, "comma separated scopes of the key (polls, admin)")
+	tier := fs.String("tier", "", "tier of the key, setting its rate limits and quotas (api.tiers)")
+	fs.Parse(args)
+	if *owner == "" {
+		return fmt.Errorf("mint: -owner is required")
+	}
+	if _, ok := tiers[*tier]; *tier != "" && !ok {
+		return fmt.Errorf("mint: unknown tier %q", *tier)
+	}
+	list := strings.Split(*scopes, ",")
+	for _, s := range list {
+		if !apikey.ValidScope(s) {
+			return fmt.Errorf("mint: unknown scope %q", s)
+		}
+	}
+	key, k, err := apikey.Mint(keys, *owner, *tier, list)
+	if err != nil {
+		return err
+	}
+	fmt.Println("id: ", k.ID)
+	fmt.Println("key:", key)
+	return nil
+}
+
+func list(keys apikey.Store) error {
+	all, err := keys.List()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tOWNER\tSCOPES\tTIER\tCREATED\tREVOKED")
+	for _, k := range all {
+		revoked := "-"
+		if k.Revoked != nil {
+			revoked = k.Revoked.Format(time.RFC3339)
+		}
+		tier := k.Tier
+		if tier == "" {
+			tier = config.DefaultTier
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", k.ID, k.Owner,
+			strings.Join(k.Scopes, ","), tier, k.Created.Format(time.RFC3339), revoked)
+	}
+	return w.Flush()
+}
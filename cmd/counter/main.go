@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gopkg.in/mgo.v2"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"socialpoll/bus"
+	"socialpoll/config"
+	"socialpoll/counter"
+	"socialpoll/poll"
+	"syscall"
+)
+
+var fatalErr error
+
+func main() {
+	defer func() {
+		if fatalErr != nil {
+			os.Exit(1)
+		}
+	}()
+
+	conf := config.Register(flag.CommandLine)
+	flag.Parse()
+	cfg, err := conf.Load()
+	if err != nil {
+		fatal(err)
+		return
+	}
+
+	var polls poll.Store
+	var timeline poll.TimelineStore
+	if cfg.Store.Backend == "memory" {
+		log.Println("Keeping polls in memory")
+		polls = poll.NewMemoryStore()
+		timeline = poll.NewMemoryTimelineStore()
+	} else {
+		log.Println("Connecting to the database...")
+		db, err := mgo.Dial(cfg.Mongo.URI)
+		if err != nil {
+			fatal(err)
+			return
+		}
+
+		defer func() {
+			log.Println("Closing database connection...")
+			db.Close()
+		}()
+		store := poll.NewMongoStore(db, cfg.Mongo.Database, cfg.Mongo.Polls)
+		if err := store.EnsureIndexes(); err != nil {
+			fatal(err)
+			return
+		}
+		polls = store
+		timelineStore := poll.NewMongoTimelineStore(db, cfg.Mongo.Database, cfg.Mongo.Timeline)
+		if err := timelineStore.EnsureIndexes(); err != nil {
+			fatal(err)
+			return
+		}
+		timeline = timelineStore
+	}
+
+	if cfg.Counter.MetricsAddr != "" {
+		go func() {
+			log.Println("Serving metrics on", cfg.Counter.MetricsAddr)
+			// expvar serves /debug/vars on the default mux
+			log.Println(http.ListenAndServe(cfg.Counter.MetricsAddr, nil))
+		}()
+	}
+
+	// stop when a signal is received
+	stopChan := make(chan struct{})
+	termChan := make(chan os.Signal, 1)
+	signal.Notify(termChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		<-termChan
+		close(stopChan)
+	}()
+
+	log.Println("Connection to NSQ...")
+	b := &bus.NSQ{
+		Nsqd:        cfg.NSQ.Nsqd,
+		Lookupd:     cfg.NSQ.Lookupd,
+		MaxInFlight: cfg.NSQ.MaxInFlight,
+	}
+	c := counter.New(polls, timeline)
+	pub, err := b.NewPublisher()
+	if err != nil {
+		fatal(err)
+		return
+	}
+	defer pub.Stop()
+	c.PublishUpdates(pub, cfg.NSQ.ResultsTopic)
+	if err := c.Run(b, cfg.NSQ.Topic, cfg.NSQ.Channel, cfg.Counter.FlushInterval, stopChan); err != nil {
+		fatal(err)
+		return
+	}
+}
+
+func fatal(e error) {
+	fmt.Println(e)
+	flag.PrintDefaults()
+	fatalErr = e
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"gopkg.in/mgo.v2"
+	"log"
+	"os"
+	"os/signal"
+	"socialpoll/bus"
+	"socialpoll/config"
+	"socialpoll/dedup"
+	"socialpoll/poll"
+	"socialpoll/twittervotes"
+	"syscall"
+)
+
+var (
+	db  *mgo.Session
+	cfg *config.Config
+)
+
+// dialdb sets up the poll and voter stores, dialing
+// MongoDB unless they are kept in memory.
+func dialdb() (*twittervotes.Tracker, error) {
+	if cfg.Store.Backend == "memory" {
+		log.Println("keeping polls in memory")
+		return &twittervotes.Tracker{
+			Polls:  poll.NewMemoryStore(),
+			Voters: dedup.NewMemoryStore(),
+		}, nil
+	}
+	var err error
+	log.Println("dialing mongodb:", cfg.Mongo.URI)
+	db, err = mgo.Dial(cfg.Mongo.URI)
+	if err != nil {
+		return nil, err
+	}
+	return &twittervotes.Tracker{
+		Polls:  poll.NewMongoStore(db, cfg.Mongo.Database, cfg.Mongo.Polls),
+		Voters: dedup.NewMongoStore(db.DB(cfg.Mongo.Database).C(cfg.Mongo.Voters)),
+	}, nil
+}
+
+func closedb() {
+	if db == nil {
+		return
+	}
+	db.Close()
+	log.Println("closed database connection")
+}
+
+func main() {
+	conf := config.Register(flag.CommandLine)
+	conf.Alias(flag.CommandLine, "sources", "votes.sources")
+	conf.Alias(flag.CommandLine, "replay", "votes.replay_file")
+	conf.Alias(flag.CommandLine, "replay-speed", "votes.replay_speed")
+	conf.Alias(flag.CommandLine, "replay-loop", "votes.replay_loop")
+	flag.Parse()
+	var err error
+	if cfg, err = conf.Load(); err != nil {
+		log.Fatalln("failed to load config:", err)
+	}
+
+	// graceful shutdown on system signals
+	stopChan := make(chan struct{})
+	signalChan := make(chan os.Signal, 1)
+	go func() {
+		<-signalChan
+		close(stopChan)
+	}()
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+
+	tracker, err := dialdb()
+	if err != nil {
+		log.Fatalln("failed to dial MongoDb:", err)
+	}
+	defer closedb()
+
+	b := &bus.NSQ{Nsqd: cfg.NSQ.Nsqd, Lookupd: cfg.NSQ.Lookupd}
+	pub, err := b.NewPublisher()
+	if err != nil {
+		log.Fatalln("can't create NSQ producer:", err)
+	}
+	if err := twittervotes.Run(voteOptions(cfg), tracker, pub, cfg.NSQ.Topic, stopChan); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// voteOptions returns the settings of the vote sources.
+func voteOptions(cfg *config.Config) twittervotes.Options {
+	return twittervotes.Options{
+		Sources: cfg.Votes.Sources,
+		Replay: twittervotes.ReplayOptions{
+			File:  cfg.Votes.ReplayFile,
+			Speed: cfg.Votes.ReplaySpeed,
+			Loop:  cfg.Votes.ReplayLoop,
+		},
+	}
+}
@@ -0,0 +1,514 @@
+// Package config loads the settings shared by all the
+// socialpoll commands.
+//
+// Every setting has a key such as "mongo.uri" and is read, in
+// increasing order of precedence, from the defaults, a config
+// file, the environment and the command line flags.
+// The config file is named by the -config flag or the SP_CONFIG
+// environment variable. Its format is our own, close to INI files
+// and not TOML: one "key = value" pair per line, grouped in
+// [sections] named after the first part of the key, with #
+// starting comments:
+//
+//	[mongo]
+//	uri = "db.example.com"
+//
+//	[counter]
+//	flush_interval = "5s" # or 5s
+//
+// A value is a double quoted string, with Go escapes, or else the
+// rest of the line, read as in the environment and flags: numbers,
+// durations and true or false may be left unquoted. Arrays, inline
+// tables and single quoted strings are rejected; lists are given
+// as one comma separated string.
+//
+// The environment variable for a key is its upper cased form
+// prefixed by SP_, with dots replaced by underscores, such as
+// SP_MONGO_URI, and its flag replaces dots and underscores by
+// dashes, such as -mongo-uri.
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the settings of all the commands.
+type Config struct {
+	Store struct {
+		// Backend is where polls are kept: "mongo", or
+		// "memory" to run without a database.
+		Backend string
+	}
+	Mongo struct {
+		// URI is the address of the MongoDB server.
+		URI string
+		// Database is the name of the database.
+		Database string
+		// Polls is the name of the collection holding polls.
+		Polls string
+		// Voters is the name of the collection recording
+		// who voted for what.
+		Voters string
+		// Timeline is the name of the collection holding
+		// the history of the results of polls.
+		Timeline string
+		// Keys is the name of the collection holding
+		// API keys.
+		Keys string
+	}
+	NSQ struct {
+		// Nsqd is the TCP address of the nsqd daemon
+		// votes are published to.
+		Nsqd string
+		// Lookupd is the HTTP address of the nsqlookupd
+		// daemon used to find vote publishers.
+		Lookupd string
+		// Topic is the topic votes are published on.
+		Topic string
+		// ResultsTopic is the topic counter announces the
+		// votes it added to the results on.
+		ResultsTopic string
+		// Channel is the channel counter reads votes from.
+		Channel string
+		// MaxInFlight is how many votes counter holds at once
+		// before acknowledging them; it should cover the votes
+		// received during a flush interval.
+		MaxInFlight int
+	}
+	Votes struct {
+		// Sources is the comma separated list of the sources
+		// twittervotes reads votes from.
+		Sources string
+		// ReplayFile is the newline delimited JSON file of
+		// tweets read by the replay source.
+		ReplayFile string
+		// ReplaySpeed divides the gaps between the timestamps
+		// of replayed tweets; 0 replays as fast as possible.
+		ReplaySpeed float64
+		// ReplayLoop starts the replay file over once it
+		// is exhausted.
+		ReplayLoop bool
+	}
+	Counter struct {
+		// FlushInterval is how often counted votes are
+		// written to the database.
+		FlushInterval time.Duration
+		// MetricsAddr is the address counter serves its
+		// metrics on, at /debug/vars; empty to disable.
+		MetricsAddr string
+	}
+	API struct {
+		// Addr is the address the API listens on.
+		Addr string
+		// KeyCacheTTL is how long the API remembers a key,
+		// and so how long a key revoked by another process
+		// keeps working.
+		KeyCacheTTL time.Duration
+		// BootstrapKey makes the API mint an admin key, and
+		// log it, when there are no keys at all. It is meant
+		// for trying the API out; keys are otherwise minted
+		// with cmd/apikey.
+		BootstrapKey bool
+		// ReadTimeout, WriteTimeout and IdleTimeout bound how
+		// long the API waits for a request, takes to write the
+		// response, and keeps idle connections open. Streams
+		// are exempt from the first two.
+		ReadTimeout  time.Duration
+		WriteTimeout time.Duration
+		IdleTimeout  time.Duration
+		// ShutdownTimeout is how long the API waits for the
+		// requests in flight to finish when it stops.
+		ShutdownTimeout time.Duration
+		// DrainDelay is how long the API keeps serving once
+		// it fails its readiness check when it stops, so that
+		// load balancers stop sending it requests first.
+		DrainDelay time.Duration
+		// Tiers holds the rate limits and quotas of API keys,
+		// by the tier of the key.
+		Tiers map[string]Tier
+		// IPRate and IPBurst limit the requests of each client
+		// address, whichever key it uses.
+		IPRate  float64
+		IPBurst int
+		// CORSOrigins lists the origins websites may call the
+		// API from: exact origins such as https://example.com,
+		// every subdomain of one as in https://*.example.com,
+		// or * for any origin.
+		CORSOrigins []string
+		// CORSCredentials lets those websites send cookies and
+		// credentials along; it needs explicit origins.
+		CORSCredentials bool
+		// CORSMaxAge is how long browsers may remember the
+		// answer to a preflight request.
+		CORSMaxAge time.Duration
+	}
+	Web struct {
+		// Addr is the address the website is served on.
+		Addr string
+	}
+}
+
+// Default returns the configuration used when nothing
+// else is given.
+func Default() *Config {
+	c := &Config{}
+	c.Store.Backend = "mongo"
+	c.Mongo.URI = "localhost"
+	c.Mongo.Database = "ballots"
+	c.Mongo.Polls = "polls"
+	c.Mongo.Voters = "voters"
+	c.Mongo.Timeline = "timeline"
+	c.Mongo.Keys = "apikeys"
+	c.NSQ.Nsqd = "localhost:4150"
+	c.NSQ.Lookupd = "localhost:4161"
+	c.NSQ.Topic = "votes"
+	c.NSQ.ResultsTopic = "results-updated"
+	c.NSQ.Channel = "counter"
+	c.NSQ.MaxInFlight = 5000
+	c.Votes.Sources = "twitter"
+	c.Counter.FlushInterval = 1 * time.Second
+	c.API.Addr = ":8080"
+	c.API.KeyCacheTTL = 1 * time.Minute
+	c.API.ReadTimeout = 10 * time.Second
+	c.API.WriteTimeout = 30 * time.Second
+	c.API.IdleTimeout = 2 * time.Minute
+	c.API.ShutdownTimeout = 30 * time.Second
+	c.API.DrainDelay = 5 * time.Second
+	c.API.Tiers = map[string]Tier{
+		DefaultTier: {Rate: 10, Burst: 20, Polls: 100, Options: 1000},
+	}
+	c.API.IPRate = 50
+	c.API.IPBurst = 100
+	c.API.CORSOrigins = []string{"*"}
+	c.API.CORSMaxAge = 10 * time.Minute
+	c.Web.Addr = ":8081"
+	return c
+}
+
+// setting is a single configurable value.
+type setting struct {
+	key   string
+	usage string
+	value flag.Value
+}
+
+// settings returns every setting of c, pointing into c.
+func (c *Config) settings() []setting {
+	return []setting{
+		{"store.backend", "where polls are kept: mongo or memory", (*stringValue)(&c.Store.Backend)},
+		{"mongo.uri", "MongoDB address", (*stringValue)(&c.Mongo.URI)},
+		{"mongo.database", "MongoDB database name", (*stringValue)(&c.Mongo.Database)},
+		{"mongo.polls", "MongoDB collection holding polls", (*stringValue)(&c.Mongo.Polls)},
+		{"mongo.voters", "MongoDB collection recording voters", (*stringValue)(&c.Mongo.Voters)},
+		{"mongo.timeline", "MongoDB collection holding poll history", (*stringValue)(&c.Mongo.Timeline)},
+		{"mongo.keys", "MongoDB collection holding API keys", (*stringValue)(&c.Mongo.Keys)},
+		{"nsq.nsqd", "nsqd TCP address votes are published to", (*stringValue)(&c.NSQ.Nsqd)},
+		{"nsq.lookupd", "nsqlookupd HTTP address", (*stringValue)(&c.NSQ.Lookupd)},
+		{"nsq.topic", "NSQ topic for votes", (*stringValue)(&c.NSQ.Topic)},
+		{"nsq.results_topic", "NSQ topic for results updates", (*stringValue)(&c.NSQ.ResultsTopic)},
+		{"nsq.channel", "NSQ channel counter reads votes from", (*stringValue)(&c.NSQ.Channel)},
+		{"nsq.max_in_flight", "how many votes counter holds before writing them", (*intValue)(&c.NSQ.MaxInFlight)},
+		{"votes.sources", "comma separated list of vote sources (twitter, replay)", (*stringValue)(&c.Votes.Sources)},
+		{"votes.replay_file", "newline delimited JSON file of tweets read by the replay source", (*stringValue)(&c.Votes.ReplayFile)},
+		{"votes.replay_speed", "replay speed multiplier for recorded timestamps (0 replays as fast as possible)", (*floatValue)(&c.Votes.ReplaySpeed)},
+		{"votes.replay_loop", "whether to start the replay file over once it is exhausted", (*boolValue)(&c.Votes.ReplayLoop)},
+		{"counter.flush_interval", "how often counter writes results", (*durationValue)(&c.Counter.FlushInterval)},
+		{"counter.metrics_addr", "address counter serves metrics on (empty to disable)", (*stringValue)(&c.Counter.MetricsAddr)},
+		{"api.addr", "API endpoint address", (*stringValue)(&c.API.Addr)},
+		{"api.key_cache_ttl", "how long the API remembers API keys", (*durationValue)(&c.API.KeyCacheTTL)},
+		{"api.bootstrap_key", "whether the API mints and logs an admin key when there are none, for trying it out", (*boolValue)(&c.API.BootstrapKey)},
+		{"api.read_timeout", "how long the API waits for a request (0 for no limit)", (*durationValue)(&c.API.ReadTimeout)},
+		{"api.write_timeout", "how long the API may take to respond (0 for no limit)", (*durationValue)(&c.API.WriteTimeout)},
+		{"api.idle_timeout", "how long the API keeps idle connections open", (*durationValue)(&c.API.IdleTimeout)},
+		{"api.shutdown_timeout", "how long the API waits for requests when stopping", (*durationValue)(&c.API.ShutdownTimeout)},
+		{"api.drain_delay", "how long the API keeps serving after failing readiness when stopping", (*durationValue)(&c.API.DrainDelay)},
+		{"api.tiers", "rate limits and quotas of API keys, by tier", (*tiersValue)(&c.API.Tiers)},
+		{"api.ip_rate", "requests allowed per client address, such as 50/s (0 for no limit)", (*rateValue)(&c.API.IPRate)},
+		{"api.ip_burst", "requests a client address may make at once", (*intValue)(&c.API.IPBurst)},
+		{"api.cors_origins", "origins websites may call the API from, comma separated, such as https://*.example.com", (*listValue)(&c.API.CORSOrigins)},
+		{"api.cors_credentials", "whether websites may call the API with credentials", (*boolValue)(&c.API.CORSCredentials)},
+		{"api.cors_max_age", "how long browsers may cache preflight responses", (*durationValue)(&c.API.CORSMaxAge)},
+		{"web.addr", "website address", (*stringValue)(&c.Web.Addr)},
+	}
+}
+
+// Validate checks that the settings make sense.
+func (c *Config) Validate() error {
+	switch c.Store.Backend {
+	case "mongo", "memory":
+	default:
+		return fmt.Errorf("config: unknown store backend %q", c.Store.Backend)
+	}
+	if c.NSQ.MaxInFlight <= 0 {
+		return errors.New("config: NSQ max in flight must be positive")
+	}
+	if c.Votes.ReplaySpeed < 0 {
+		return errors.New("config: replay speed must not be negative")
+	}
+	if c.Counter.FlushInterval <= 0 {
+		return errors.New("config: counter flush interval must be positive")
+	}
+	if c.API.KeyCacheTTL < 0 {
+		return errors.New("config: API key cache TTL must not be negative")
+	}
+	if c.API.ReadTimeout < 0 || c.API.WriteTimeout < 0 || c.API.IdleTimeout < 0 {
+		return errors.New("config: API timeouts must not be negative")
+	}
+	if c.API.DrainDelay < 0 {
+		return errors.New("config: API drain delay must not be negative")
+	}
+	if c.API.ShutdownTimeout <= 0 {
+		return errors.New("config: API shutdown timeout must be positive")
+	}
+	if c.API.IPBurst < 0 {
+		return errors.New("config: API IP burst must not be negative")
+	}
+	for _, origin := range c.API.CORSOrigins {
+		if origin == "*" {
+			if c.API.CORSCredentials {
+				return errors.New("config: API CORS credentials need explicit origins, not *")
+			}
+			continue
+		}
+		if err := validOrigin(origin); err != nil {
+			return fmt.Errorf("config: invalid CORS origin %q: %v", origin, err)
+		}
+	}
+	if c.API.CORSMaxAge < 0 {
+		return errors.New("config: API CORS max age must not be negative")
+	}
+	return nil
+}
+
+// Set sets the setting with the given key.
+func (c *Config) Set(key, value string) error {
+	for _, s := range c.settings() {
+		if s.key == key {
+			if err := s.value.Set(value); err != nil {
+				return fmt.Errorf("config: invalid value for %s: %v", key, err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("config: unknown setting %q", key)
+}
+
+// Write writes c in the config file format.
+func (c *Config) Write(w io.Writer) error {
+	section := ""
+	for _, s := range c.settings() {
+		i := strings.Index(s.key, ".")
+		if s.key[:i] != section {
+			if section != "" {
+				fmt.Fprintln(w)
+			}
+			section = s.key[:i]
+			fmt.Fprintf(w, "[%s]\n", section)
+		}
+		if _, err := fmt.Fprintf(w, "%s = %q\n", s.key[i+1:], s.value.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadFile sets the settings found in the named config file.
+func (c *Config) ReadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.read(path, f)
+}
+
+func (c *Config) read(name string, r io.Reader) error {
+	section := ""
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return fmt.Errorf("%s:%d: expected key = value", name, n)
+		}
+		key := strings.TrimSpace(line[:i])
+		if section != "" {
+			key = section + "." + key
+		}
+		value := strings.TrimSpace(line[i+1:])
+		switch {
+		case strings.HasPrefix(value, `"`):
+			v, err := strconv.Unquote(value)
+			if err != nil {
+				return fmt.Errorf("%s:%d: invalid string %s", name, n, value)
+			}
+			value = v
+		case strings.HasPrefix(value, "["), strings.HasPrefix(value, "{"), strings.HasPrefix(value, "'"):
+			return fmt.Errorf("%s:%d: unsupported value %s; give a double quoted string, "+
+				"with lists comma separated", name, n, value)
+		}
+		if err := c.Set(key, value); err != nil {
+			return fmt.Errorf("%s:%d: %v", name, n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// stripComment removes a # comment that is not
+// inside a quoted string from the end of line.
+func stripComment(line string) string {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case '#':
+			if !quoted {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+// EnvName returns the name of the environment variable
+// overriding the setting with the given key.
+func EnvName(key string) string {
+	return "SP_" + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+}
+
+// FlagName returns the name of the flag overriding the
+// setting with the given key.
+func FlagName(key string) string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(key)
+}
+
+// ReadEnv sets the settings found in the environment.
+func (c *Config) ReadEnv() error {
+	for _, s := range c.settings() {
+		if v, ok := os.LookupEnv(EnvName(s.key)); ok {
+			if err := s.value.Set(v); err != nil {
+				return fmt.Errorf("config: invalid value for %s: %v", EnvName(s.key), err)
+			}
+		}
+	}
+	return nil
+}
+
+type stringValue string
+
+func (v *stringValue) Set(s string) error {
+	*v = stringValue(s)
+	return nil
+}
+
+func (v *stringValue) String() string { return string(*v) }
+
+type intValue int
+
+func (v *intValue) Set(s string) error {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*v = intValue(i)
+	return nil
+}
+
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+
+type floatValue float64
+
+func (v *floatValue) Set(s string) error {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*v = floatValue(f)
+	return nil
+}
+
+func (v *floatValue) String() string { return strconv.FormatFloat(float64(*v), 'f', -1, 64) }
+
+type durationValue time.Duration
+
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*v = durationValue(d)
+	return nil
+}
+
+func (v *durationValue) String() string { return time.Duration(*v).String() }
+
+type boolValue bool
+
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*v = boolValue(b)
+	return nil
+}
+
+func (v *boolValue) String() string { return strconv.FormatBool(bool(*v)) }
+
+// listValue is a comma separated list.
+type listValue []string
+
+func (v *listValue) Set(s string) error {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	*v = list
+	return nil
+}
+
+func (v *listValue) String() string { return strings.Join(*v, ",") }
+
+// validOrigin checks that origin is a scheme and a host,
+// optionally with a port, whose host may start with *.
+// to stand for any of its subdomains.
+func validOrigin(origin string) error {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return errors.New("expected scheme://host")
+	}
+	if u.User != nil || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+		return errors.New("expected only a scheme, host and port")
+	}
+	host := strings.TrimPrefix(u.Hostname(), "*.")
+	if host == "" || strings.Contains(host, "*") {
+		return errors.New("wildcards may only stand for a subdomain, as in *.example.com")
+	}
+	return nil
+}
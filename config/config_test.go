@@ -0,0 +1,83 @@
+package config
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRead(t *testing.T) {
+	c := Default()
+	err := c.read("test.conf", strings.NewReader(`
+# settings
+[mongo]
+uri = "db.example.com:27017" # primary
+database = ballots#2
+
+[counter]
+flush_interval = 5s
+
+[votes]
+replay_speed = 2.5
+replay_loop = true
+
+[api]
+cors_origins = "https://a.example.com, https://*.example.org"
+ip_burst = 30
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Mongo.URI != "db.example.com:27017" || c.Mongo.Database != "ballots" {
+		t.Errorf("unexpected mongo settings %q, %q", c.Mongo.URI, c.Mongo.Database)
+	}
+	if c.Counter.FlushInterval != 5*time.Second || c.Votes.ReplaySpeed != 2.5 || !c.Votes.ReplayLoop || c.API.IPBurst != 30 {
+		t.Errorf("unexpected settings %v, %v, %v, %v", c.Counter.FlushInterval, c.Votes.ReplaySpeed, c.Votes.ReplayLoop, c.API.IPBurst)
+	}
+	if want := []string{"https://a.example.com", "https://*.example.org"}; !reflect.DeepEqual(c.API.CORSOrigins, want) {
+		t.Errorf("expected origins %v, got %v", want, c.API.CORSOrigins)
+	}
+}
+
+func TestReadRejects(t *testing.T) {
+	tests := []struct {
+		name, line, err string
+	}{
+		{"array", `cors_origins = ["https://a.example.com"]`, "unsupported value"},
+		{"inline table", `tiers = {default = "rate=1/s"}`, "unsupported value"},
+		{"single quotes", `addr = ':8080'`, "unsupported value"},
+		{"bad string", `addr = "unterminated`, "invalid string"},
+		{"no value", `addr`, "expected key = value"},
+		{"unknown key", `port = 8080`, "unknown setting"},
+		{"invalid number", `ip_burst = many`, "ip_burst"},
+	}
+	for _, test := range tests {
+		err := Default().read("test.conf", strings.NewReader("[api]\n"+test.line+"\n"))
+		if err == nil || !strings.Contains(err.Error(), test.err) || !strings.HasPrefix(err.Error(), "test.conf:2:") {
+			t.Errorf("%s: expected an error about %s on line 2, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestWriteReadsBack(t *testing.T) {
+	c := Default()
+	c.Mongo.URI = `db "primary"`
+	c.API.CORSOrigins = []string{"https://a.example.com", "https://b.example.com"}
+	c.Votes.ReplayLoop = true
+	var buf bytes.Buffer
+	if err := c.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read := Default()
+	if err := read.read("written", &buf); err != nil {
+		t.Fatal(err)
+	}
+	var want, got bytes.Buffer
+	c.Write(&want)
+	read.Write(&got)
+	if want.String() != got.String() {
+		t.Errorf("expected\n%s\ngot\n%s", want.String(), got.String())
+	}
+}
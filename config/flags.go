@@ -0,0 +1,103 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Loader loads the configuration of a command,
+// taking the command line flags into account.
+type Loader struct {
+	path  *string
+	print *bool
+	flags map[string]string // key -> value given on the command line
+}
+
+// Register defines a flag for every setting in fs, along with
+// the -config and -print-config flags, and returns a Loader
+// to call once the flags are parsed.
+func Register(fs *flag.FlagSet) *Loader {
+	l := &Loader{
+		path:  fs.String("config", "", "config file to read (overrides $SP_CONFIG)"),
+		print: fs.Bool("print-config", false, "print the configuration and exit"),
+		flags: make(map[string]string),
+	}
+	for _, s := range Default().settings() {
+		l.Alias(fs, FlagName(s.key), s.key)
+	}
+	return l
+}
+
+// Alias defines an extra flag in fs overriding the setting
+// with the given key, so commands can keep their old flags.
+func (l *Loader) Alias(fs *flag.FlagSet, name, key string) {
+	c := Default()
+	for _, s := range c.settings() {
+		if s.key == key {
+			_, isBool := s.value.(*boolValue)
+			fs.Var(&flagValue{l: l, key: key, value: s.value.String(), isBool: isBool}, name,
+				fmt.Sprintf("%s (%s)", s.usage, EnvName(key)))
+			return
+		}
+	}
+	panic("config: alias for unknown setting " + key)
+}
+
+// Load returns the configuration made of the defaults, the
+// config file, the environment and the flags, in that order,
+// checking that it is valid.
+// If -print-config was given, Load prints the configuration
+// and exits.
+func (l *Loader) Load() (*Config, error) {
+	c := Default()
+	path := *l.path
+	if path == "" {
+		path = os.Getenv("SP_CONFIG")
+	}
+	if path != "" {
+		if err := c.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.ReadEnv(); err != nil {
+		return nil, err
+	}
+	for key, value := range l.flags {
+		if err := c.Set(key, value); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	if *l.print {
+		c.Write(os.Stdout)
+		os.Exit(0)
+	}
+	return c, nil
+}
+
+// flagValue records the value given to a setting's flag,
+// to be applied once the lower precedence sources are read.
+type flagValue struct {
+	l      *Loader
+	key    string
+	value  string
+	isBool bool
+}
+
+func (v *flagValue) Set(s string) error {
+	if err := Default().Set(v.key, s); err != nil {
+		return err
+	}
+	v.value = s
+	v.l.flags[v.key] = s
+	return nil
+}
+
+func (v *flagValue) String() string { return v.value }
+
+// IsBoolFlag lets boolean settings be given as -flag,
+// meaning -flag=true.
+func (v *flagValue) IsBoolFlag() bool { return v.isBool }
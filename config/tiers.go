@@ -0,0 +1,157 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTier is the tier of API keys minted without one.
+const DefaultTier = "default"
+
+// Tier holds the limits of the API keys of a tier.
+// Zero values mean no limit.
+type Tier struct {
+	// Rate is how many requests a key may make a second,
+	// on average, and Burst how many it may make at once.
+	Rate  float64
+	Burst int
+	// Polls is how many polls the owner of a key may have,
+	// and Options how many options between them.
+	Polls   int
+	Options int
+}
+
+// tiersValue holds tiers by name, written as
+//
+//	default rate=10/s burst=20 polls=100 options=1000; partner rate=100/s
+//
+// where rates are given per second, minute or hour.
+type tiersValue map[string]Tier
+
+func (v *tiersValue) Set(s string) error {
+	tiers := make(tiersValue)
+	for _, entry := range strings.Split(s, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		name := fields[0]
+		if strings.Contains(name, "=") {
+			return fmt.Errorf("tier %q has no name", entry)
+		}
+		var t Tier
+		for _, field := range fields[1:] {
+			i := strings.Index(field, "=")
+			if i < 0 {
+				return fmt.Errorf("tier %s: expected limit=value, got %q", name, field)
+			}
+			limit, value := field[:i], field[i+1:]
+			var err error
+			switch limit {
+			case "rate":
+				t.Rate, err = parseRate(value)
+			case "burst":
+				t.Burst, err = strconv.Atoi(value)
+			case "polls":
+				t.Polls, err = strconv.Atoi(value)
+			case "options":
+				t.Options, err = strconv.Atoi(value)
+			default:
+				return fmt.Errorf("tier %s: unknown limit %q", name, limit)
+			}
+			if err != nil {
+				return fmt.Errorf("tier %s: invalid %s %q", name, limit, value)
+			}
+		}
+		if t.Rate < 0 || t.Burst < 0 || t.Polls < 0 || t.Options < 0 {
+			return fmt.Errorf("tier %s: limits must not be negative", name)
+		}
+		tiers[name] = t
+	}
+	*v = tiers
+	return nil
+}
+
+func (v *tiersValue) String() string {
+	names := make([]string, 0, len(*v))
+	for name := range *v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	entries := make([]string, len(names))
+	for i, name := range names {
+		t := (*v)[name]
+		entry := name
+		if t.Rate != 0 {
+			entry += " rate=" + formatRate(t.Rate)
+		}
+		if t.Burst != 0 {
+			entry += " burst=" + strconv.Itoa(t.Burst)
+		}
+		if t.Polls != 0 {
+			entry += " polls=" + strconv.Itoa(t.Polls)
+		}
+		if t.Options != 0 {
+			entry += " options=" + strconv.Itoa(t.Options)
+		}
+		entries[i] = entry
+	}
+	return strings.Join(entries, "; ")
+}
+
+// rateValue is a rate per second, written as 10/s, 30/m or 100/h.
+type rateValue float64
+
+func (v *rateValue) Set(s string) error {
+	r, err := parseRate(s)
+	if err != nil {
+		return fmt.Errorf("invalid rate %q", s)
+	}
+	*v = rateValue(r)
+	return nil
+}
+
+func (v *rateValue) String() string { return formatRate(float64(*v)) }
+
+// rateUnits are the units of rates.
+var rateUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+}
+
+// parseRate returns the rate per second of a rate such as
+// 10/s or 30/m; plain numbers are per second.
+func parseRate(s string) (float64, error) {
+	unit := time.Second
+	if i := strings.Index(s, "/"); i >= 0 {
+		u, ok := rateUnits[s[i+1:]]
+		if !ok {
+			return 0, fmt.Errorf("unknown rate unit %q", s[i+1:])
+		}
+		s, unit = s[:i], u
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("negative rate %v", n)
+	}
+	return n / unit.Seconds(), nil
+}
+
+// formatRate writes a rate per second in the unit
+// giving a whole number, if any.
+func formatRate(r float64) string {
+	for _, unit := range []string{"s", "m", "h"} {
+		n := r * rateUnits[unit].Seconds()
+		if n == float64(int64(n)) {
+			return strconv.FormatFloat(n, 'f', -1, 64) + "/" + unit
+		}
+	}
+	return strconv.FormatFloat(r, 'f', -1, 64) + "/s"
+}
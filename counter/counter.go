@@ -0,0 +1,330 @@
+// Package counter counts the votes published on the bus,
+// keeping the counts in memory and periodically adding them
+// to the results of the polls.
+// Each batch added may be announced on another topic, so that
+// live results can be pushed to clients.
+//
+// Votes are only acknowledged once they have been written,
+// so votes counted by a counter that crashes are delivered
+// again rather than lost. A batch that keeps failing is
+// written poll by poll, and the votes of the polls that still
+// fail are requeued, so one broken poll does not hold up the
+// votes of the others.
+package counter
+
+import (
+	"expvar"
+	"gopkg.in/mgo.v2/bson"
+	"log"
+	"socialpoll/bus"
+	"socialpoll/poll"
+	"socialpoll/results"
+	"socialpoll/vote"
+	"sync"
+	"time"
+)
+
+// metrics describes the flushes of all counters, published
+// with expvar under "counter":
+//
+//	flushes                number of batches written
+//	flush_errors           number of failed attempts at writing a batch
+//	requeued_votes         number of votes given back to the bus
+//	votes                  number of votes written
+//	last_batch_votes       votes in the last batch written
+//	last_batch_polls       polls in the last batch written
+//	last_flush_ms          time taken by the last write
+//	flush_ms               total time taken by writes
+var metrics = expvar.NewMap("counter")
+
+// Counter counts votes.
+type Counter struct {
+	polls    poll.Store
+	timeline poll.TimelineStore
+
+	countsLock sync.Mutex                // protects counts, history, pending and received
+	counts     map[string]map[string]int // poll ID -> option -> count
+	history    poll.History              // counts by the minute votes were cast
+	pending    map[string][]bus.Message  // poll ID -> the messages of the votes in counts
+	received   time.Time                 // when the last vote in counts was received
+
+	// batch is the batch being written, kept until it
+	// was written. It is only used by doCount.
+	batch *batch
+
+	// updates, when set, is where written batches are
+	// announced, on updatesTopic.
+	updates      bus.Publisher
+	updatesTopic string
+}
+
+// batch is a set of counts written to the polls
+// as a whole, along with the messages they came from.
+type batch struct {
+	id      string
+	counts  map[string]map[string]int
+	history poll.History
+	// received is when the last vote of the batch was received;
+	// polls accepting votes then take the batch, even if they
+	// closed while it was waiting to be written.
+	received time.Time
+	messages map[string][]bus.Message // by poll ID
+	attempts int
+}
+
+// maxBatchAttempts is how many times a batch is written as
+// a whole before its polls are written one by one.
+const maxBatchAttempts = 5
+
+// New creates a Counter adding the votes it counts to the
+// polls in the store and, unless it is nil, to their timelines.
+func New(polls poll.Store, timeline poll.TimelineStore) *Counter {
+	return &Counter{polls: polls, timeline: timeline}
+}
+
+// PublishUpdates makes the counter announce every batch it
+// writes on the topic, as a results.Update.
+func (c *Counter) PublishUpdates(pub bus.Publisher, topic string) {
+	c.updates = pub
+	c.updatesTopic = topic
+}
+
+// HandleVote is a bus.Handler counting a vote.
+// The message is held until the vote is written.
+func (c *Counter) HandleVote(m bus.Message) error {
+	v, err := vote.Decode(m.Body())
+	if err != nil {
+		// a malformed message will never decode,
+		// so there is no point in requeueing it
+		log.Println("discarding vote:", err)
+		return nil
+	}
+	m.DisableAutoResponse()
+
+	c.countsLock.Lock()
+	defer c.countsLock.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]map[string]int)
+	}
+	if c.counts[v.PollID] == nil {
+		c.counts[v.PollID] = make(map[string]int)
+	}
+	n := 1
+	if v.Retract {
+		n = -1
+	}
+	c.counts[v.PollID][v.Option] += n
+	if c.history == nil {
+		c.history = make(poll.History)
+	}
+	at := v.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+	c.history.Add(v.PollID, v.Option, at, n)
+	if c.pending == nil {
+		c.pending = make(map[string][]bus.Message)
+	}
+	c.pending[v.PollID] = append(c.pending[v.PollID], m)
+	c.received = time.Now()
+	return nil
+}
+
+// Run counts the votes of the channel of the topic, updating
+// the polls every interval, until stop is closed.
+func (c *Counter) Run(b bus.Bus, topic, channel string, interval time.Duration, stop <-chan struct{}) error {
+	q, err := b.NewConsumer(topic, channel, c.HandleVote)
+	if err != nil {
+		return err
+	}
+
+	// update database periodically with new counts
+	// or stop when asked to; the consumer waits for held
+	// messages to be acknowledged before it stops, so
+	// updates carry on until then
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.doCount()
+		case <-stop:
+			stop = nil
+			c.doCount()
+			q.Stop()
+		case <-q.Stopped():
+			// finished
+			return nil
+		}
+	}
+}
+
+// doCount checks to see whether there are any values in the counts map.
+// If there aren't it will log that it is skipping the update and wait
+// for next time.
+// The counts are written as a batch, in a single call to the store
+// followed by one to the timeline store;
+// the messages of the batch are acknowledged once it succeeds. Until
+// then, the batch is retried instead of moving on to new counts, and
+// as the store ignores batches a poll already has, retries never
+// count a vote twice. Every held message is touched meanwhile, so
+// none is delivered again while it waits. After maxBatchAttempts,
+// the polls of the batch are written one by one, under the same
+// batch ID, and the votes of those that fail are requeued: as their
+// polls do not hold the batch, they are not counted twice either.
+func (c *Counter) doCount() {
+	if c.batch == nil {
+		c.countsLock.Lock()
+		if len(c.pending) == 0 {
+			c.countsLock.Unlock()
+			log.Println("No new votes, skipping database update...")
+			return
+		}
+		c.batch = &batch{
+			id:       bson.NewObjectId().Hex(),
+			counts:   c.counts,
+			history:  c.history,
+			received: c.received,
+			messages: c.pending,
+		}
+		c.counts, c.history, c.pending = nil, nil, nil
+		c.countsLock.Unlock()
+	}
+
+	b := c.batch
+	b.attempts++
+	log.Println("Updating database...")
+	log.Println(b.id, b.counts)
+	start := time.Now()
+	credited, err := c.write(b)
+	failed := map[string]bool{}
+	if err != nil {
+		metrics.Add("flush_errors", 1)
+		log.Println("failed to update:", err)
+		if b.attempts < maxBatchAttempts {
+			c.touchAll()
+			log.Println("Will retry batch", b.id)
+			return
+		}
+		log.Println("Writing the polls of batch", b.id, "one by one")
+		credited, failed = c.writeEach(b)
+	}
+	elapsed := float64(time.Since(start)) / float64(time.Millisecond)
+	metrics.AddFloat("flush_ms", elapsed)
+	lastFlush := new(expvar.Float)
+	lastFlush.Set(elapsed)
+	metrics.Set("last_flush_ms", lastFlush)
+
+	votes := 0
+	for id, messages := range b.messages {
+		if failed[id] {
+			log.Printf("Requeueing %d votes for poll %s", len(messages), id)
+			for _, m := range messages {
+				m.Requeue()
+			}
+			metrics.Add("requeued_votes", int64(len(messages)))
+			continue
+		}
+		for _, m := range messages {
+			m.Finish()
+		}
+		votes += len(messages)
+	}
+	c.publishUpdate(b.id, credited)
+	metrics.Add("flushes", 1)
+	metrics.Add("votes", int64(votes))
+	batchVotes, batchPolls := new(expvar.Int), new(expvar.Int)
+	batchVotes.Set(int64(votes))
+	batchPolls.Set(int64(len(credited)))
+	metrics.Set("last_batch_votes", batchVotes)
+	metrics.Set("last_batch_polls", batchPolls)
+	log.Printf("Finished updating database (%d votes for %d polls in %.1fms)...",
+		votes, len(credited), elapsed)
+	c.batch = nil
+}
+
+// write adds the counts of the batch to the polls, and the
+// history of the polls credited to their timelines.
+func (c *Counter) write(b *batch) (map[string]map[string]int, error) {
+	credited, err := c.polls.IncrementResults(b.id, b.received, b.counts)
+	if err == nil && c.timeline != nil {
+		err = c.timeline.AddHistory(b.id, creditedHistory(b.history, credited))
+	}
+	return credited, err
+}
+
+// writeEach writes the batch one poll at a time, returning
+// the counts credited and the polls that failed. A poll whose
+// results were written counts as credited even if its history
+// was not, as requeueing its votes would count them twice.
+func (c *Counter) writeEach(b *batch) (credited map[string]map[string]int, failed map[string]bool) {
+	credited = make(map[string]map[string]int)
+	failed = make(map[string]bool)
+	for id, options := range b.counts {
+		cr, err := c.polls.IncrementResults(b.id, b.received, map[string]map[string]int{id: options})
+		if err != nil {
+			log.Println("failed to update poll", id+":", err)
+			failed[id] = true
+			continue
+		}
+		for id, options := range cr {
+			credited[id] = options
+		}
+		if c.timeline == nil {
+			continue
+		}
+		if err := c.timeline.AddHistory(b.id, creditedHistory(b.history, cr)); err != nil {
+			log.Println("failed to add the history of poll", id+":", err)
+		}
+	}
+	return credited, failed
+}
+
+// touchAll keeps every held message from timing out: those
+// of the batch being written, and those of the votes counted
+// since.
+func (c *Counter) touchAll() {
+	for _, messages := range c.batch.messages {
+		for _, m := range messages {
+			m.Touch()
+		}
+	}
+	c.countsLock.Lock()
+	defer c.countsLock.Unlock()
+	for _, messages := range c.pending {
+		for _, m := range messages {
+			m.Touch()
+		}
+	}
+}
+
+// creditedHistory returns the history of the polls the
+// counts were credited to, so polls that took no votes,
+// such as closed ones, get none in their timelines either.
+func creditedHistory(h poll.History, credited map[string]map[string]int) poll.History {
+	result := make(poll.History, len(credited))
+	for id := range credited {
+		if minutes, ok := h[id]; ok {
+			result[id] = minutes
+		}
+	}
+	return result
+}
+
+// publishUpdate announces the counts credited by the batch.
+// Updates that fail to publish are only logged: the results
+// are safe, and clients catch up on their next snapshot.
+func (c *Counter) publishUpdate(batch string, credited map[string]map[string]int) {
+	if c.updates == nil || len(credited) == 0 {
+		return
+	}
+	u := &results.Update{Batch: batch, Time: time.Now().UTC(), Counts: credited}
+	body, err := u.Encode()
+	if err != nil {
+		log.Println("failed to encode update:", err)
+		return
+	}
+	if err := c.updates.Publish(c.updatesTopic, body); err != nil {
+		log.Println("failed to publish update:", err)
+	}
+}
@@ -0,0 +1,432 @@
+package counter
+
+import (
+	"errors"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"socialpoll/poll"
+	"socialpoll/results"
+	"socialpoll/vote"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testMessage is a bus.Message recording what was done with it.
+type testMessage struct {
+	body     []byte
+	finished int
+	requeued int
+	touched  int
+}
+
+func (m *testMessage) Body() []byte         { return m.body }
+func (m *testMessage) DisableAutoResponse() {}
+func (m *testMessage) Finish()              { m.finished++ }
+func (m *testMessage) Requeue()             { m.requeued++ }
+func (m *testMessage) Touch()               { m.touched++ }
+
+// testPublisher is a bus.Publisher recording what it publishes.
+type testPublisher struct {
+	lock      sync.Mutex
+	published [][]byte
+}
+
+func (p *testPublisher) Publish(topic string, body []byte) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.published = append(p.published, body)
+	return nil
+}
+
+func (p *testPublisher) Stop() {}
+
+func (p *testPublisher) updates(t *testing.T) []*results.Update {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var result []*results.Update
+	for _, body := range p.published {
+		u, err := results.Decode(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, u)
+	}
+	return result
+}
+
+// castVote hands the counter a vote for the option of the poll,
+// returning its message.
+func castVote(t *testing.T, c *Counter, id, option string, at time.Time) *testMessage {
+	body, err := (&vote.Vote{PollID: id, Option: option, Source: "test", Time: at}).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &testMessage{body: body}
+	if err := c.HandleVote(m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func createPoll(t *testing.T, s poll.Store, status poll.Status, options ...string) string {
+	p := &poll.Poll{Title: "test", Options: options, Status: status}
+	if err := s.Create(p); err != nil {
+		t.Fatal(err)
+	}
+	return p.ID.Hex()
+}
+
+func TestDoCountSkipsPollsNotAcceptingVotes(t *testing.T) {
+	polls := poll.NewMemoryStore()
+	timeline := poll.NewMemoryTimelineStore()
+	open := createPoll(t, polls, poll.Open, "a", "b")
+	closed := createPoll(t, polls, poll.Closed, "a", "b")
+	pub := &testPublisher{}
+	c := New(polls, timeline)
+	c.PublishUpdates(pub, "results")
+
+	now := time.Now()
+	castVote(t, c, open, "a", now)
+	castVote(t, c, closed, "a", now)
+	c.doCount()
+
+	p, err := polls.Get(closed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Results) != 0 || p.Total != 0 {
+		t.Errorf("closed poll got votes: %v", p.Results)
+	}
+	buckets, err := timeline.Timeline(closed, poll.Minute, now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 0 {
+		t.Errorf("closed poll got history: %v", buckets)
+	}
+	buckets, err = timeline.Timeline(open, poll.Minute, now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 1 || buckets[0].Counts["a"] != 1 {
+		t.Errorf("expected one vote for a in the open poll's history, got %v", buckets)
+	}
+	updates := pub.updates(t)
+	if len(updates) != 1 {
+		t.Fatalf("expected one update, got %d", len(updates))
+	}
+	want := map[string]map[string]int{open: {"a": 1}}
+	if !reflect.DeepEqual(updates[0].Counts, want) {
+		t.Errorf("expected update %v, got %v", want, updates[0].Counts)
+	}
+}
+
+func TestDoCountPublishesNothingWithoutCreditedPolls(t *testing.T) {
+	polls := poll.NewMemoryStore()
+	closed := createPoll(t, polls, poll.Closed, "a")
+	pub := &testPublisher{}
+	c := New(polls, nil)
+	c.PublishUpdates(pub, "results")
+	m := castVote(t, c, closed, "a", time.Now())
+	c.doCount()
+	if m.finished != 1 {
+		t.Errorf("expected the vote to be finished once, got %d", m.finished)
+	}
+	if n := len(pub.updates(t)); n != 0 {
+		t.Errorf("expected no update, got %d", n)
+	}
+}
+
+// failingStore is a poll.Store failing to increment the results
+// of the polls in failing, or of any poll while down is set.
+type failingStore struct {
+	*poll.MemoryStore
+	down    bool
+	failing map[string]bool
+}
+
+func (s *failingStore) IncrementResults(batch string, at time.Time, counts map[string]map[string]int) (map[string]map[string]int, error) {
+	if s.down {
+		return nil, errors.New("store is down")
+	}
+	for id := range counts {
+		if s.failing[id] {
+			return nil, errors.New("cannot update " + id)
+		}
+	}
+	return s.MemoryStore.IncrementResults(batch, at, counts)
+}
+
+func TestDoCountAcknowledgesOnlyWrittenVotes(t *testing.T) {
+	store := &failingStore{MemoryStore: poll.NewMemoryStore(), down: true}
+	id := createPoll(t, store, poll.Open, "a")
+	c := New(store, nil)
+
+	first := castVote(t, c, id, "a", time.Now())
+	c.doCount()
+	if first.finished != 0 || first.touched != 1 {
+		t.Fatalf("failed write: expected the vote to be touched only, got %+v", first)
+	}
+	second := castVote(t, c, id, "a", time.Now())
+	c.doCount()
+	if first.touched != 2 || second.touched != 1 {
+		t.Errorf("expected the batch and pending votes to be touched, got %d and %d", first.touched, second.touched)
+	}
+	if first.finished != 0 || second.finished != 0 {
+		t.Errorf("expected no vote to be finished before the write")
+	}
+
+	store.down = false
+	c.doCount()
+	if first.finished != 1 || second.finished != 0 {
+		t.Errorf("expected only the batch to be finished, got %d and %d", first.finished, second.finished)
+	}
+	c.doCount()
+	if second.finished != 1 {
+		t.Errorf("expected the next batch to be finished, got %d", second.finished)
+	}
+	p, err := store.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Results["a"] != 2 || p.Total != 2 {
+		t.Errorf("expected 2 votes for a, got %v (total %d)", p.Results, p.Total)
+	}
+}
+
+func TestDoCountRetriesBatchOnce(t *testing.T) {
+	polls := poll.NewMemoryStore()
+	id := createPoll(t, polls, poll.Open, "a", "b")
+	// a timeline failing once makes the batch be retried
+	// after the results were written
+	timeline := &failingTimeline{TimelineStore: poll.NewMemoryTimelineStore(), failures: 1}
+	c := New(polls, timeline)
+	castVote(t, c, id, "a", time.Now())
+	castVote(t, c, id, "b", time.Now())
+	c.doCount()
+	c.doCount()
+	p, err := polls.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"a": 1, "b": 1}; !reflect.DeepEqual(p.Results, want) || p.Total != 2 {
+		t.Errorf("expected %v, got %v (total %d)", want, p.Results, p.Total)
+	}
+	if c.batch != nil {
+		t.Errorf("expected the batch to be written")
+	}
+}
+
+// failingTimeline is a poll.TimelineStore whose next
+// failures calls to AddHistory fail.
+type failingTimeline struct {
+	poll.TimelineStore
+	failures int
+}
+
+func (s *failingTimeline) AddHistory(batch string, h poll.History) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("timeline is down")
+	}
+	return s.TimelineStore.AddHistory(batch, h)
+}
+
+func TestDoCountIsolatesFailingPoll(t *testing.T) {
+	store := &failingStore{MemoryStore: poll.NewMemoryStore(), failing: map[string]bool{}}
+	good := createPoll(t, store, poll.Open, "a")
+	bad := createPoll(t, store, poll.Open, "a")
+	store.failing[bad] = true
+	pub := &testPublisher{}
+	c := New(store, nil)
+	c.PublishUpdates(pub, "results")
+
+	goodVote := castVote(t, c, good, "a", time.Now())
+	badVote := castVote(t, c, bad, "a", time.Now())
+	for i := 1; i < maxBatchAttempts; i++ {
+		c.doCount()
+		if c.batch == nil || goodVote.finished+badVote.finished+badVote.requeued != 0 {
+			t.Fatalf("attempt %d: expected the batch to be retried", i)
+		}
+	}
+	c.doCount()
+	if c.batch != nil {
+		t.Fatalf("expected the counter to move on after %d attempts", maxBatchAttempts)
+	}
+	if goodVote.finished != 1 || goodVote.requeued != 0 {
+		t.Errorf("expected the vote for the good poll to be finished, got %+v", goodVote)
+	}
+	if badVote.finished != 0 || badVote.requeued != 1 {
+		t.Errorf("expected the vote for the bad poll to be requeued, got %+v", badVote)
+	}
+	p, err := store.Get(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Results["a"] != 1 {
+		t.Errorf("expected the good poll to have its vote, got %v", p.Results)
+	}
+	updates := pub.updates(t)
+	want := map[string]map[string]int{good: {"a": 1}}
+	if len(updates) != 1 || !reflect.DeepEqual(updates[0].Counts, want) {
+		t.Errorf("expected one update with %v, got %v", want, updates)
+	}
+
+	// new votes are not held up by the broken poll
+	next := castVote(t, c, good, "a", time.Now())
+	c.doCount()
+	if next.finished != 1 {
+		t.Errorf("expected the next vote to be finished, got %+v", next)
+	}
+}
+
+func TestDoCount(t *testing.T) {
+	type cast struct {
+		poll, option string
+		retract      bool
+	}
+	tests := []struct {
+		name    string
+		votes   []cast
+		results map[string]map[string]int
+		updates map[string]map[string]int
+	}{
+		{"no votes", nil, map[string]map[string]int{"p1": nil, "p2": nil}, nil},
+		{"votes",
+			[]cast{{"p1", "a", false}, {"p1", "a", false}, {"p1", "b", false}, {"p2", "a", false}},
+			map[string]map[string]int{"p1": {"a": 2, "b": 1}, "p2": {"a": 1}},
+			map[string]map[string]int{"p1": {"a": 2, "b": 1}, "p2": {"a": 1}}},
+		{"retracted votes",
+			[]cast{{"p1", "a", false}, {"p1", "a", true}, {"p1", "b", false}},
+			map[string]map[string]int{"p1": {"a": 0, "b": 1}, "p2": nil},
+			map[string]map[string]int{"p1": {"a": 0, "b": 1}}},
+		{"votes for deleted polls",
+			[]cast{{"gone", "a", false}, {"p2", "b", false}},
+			map[string]map[string]int{"p1": nil, "p2": {"b": 1}},
+			map[string]map[string]int{"p2": {"b": 1}}},
+		{"votes for closed polls",
+			[]cast{{"closed", "a", false}},
+			map[string]map[string]int{"p1": nil, "p2": nil, "closed": nil},
+			nil},
+	}
+	for _, test := range tests {
+		polls := poll.NewMemoryStore()
+		ids := map[string]string{
+			"p1":     createPoll(t, polls, poll.Open, "a", "b"),
+			"p2":     createPoll(t, polls, poll.Open, "a", "b"),
+			"closed": createPoll(t, polls, poll.Closed, "a", "b"),
+			"gone":   bson.NewObjectId().Hex(),
+		}
+		names := make(map[string]string)
+		for name, id := range ids {
+			names[id] = name
+		}
+		pub := &testPublisher{}
+		c := New(polls, poll.NewMemoryTimelineStore())
+		c.PublishUpdates(pub, "results")
+		var messages []*testMessage
+		for _, v := range test.votes {
+			body, err := (&vote.Vote{PollID: ids[v.poll], Option: v.option, Retract: v.retract, Time: time.Now()}).Encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := &testMessage{body: body}
+			c.HandleVote(m)
+			messages = append(messages, m)
+		}
+		c.doCount()
+
+		for _, m := range messages {
+			if m.finished != 1 || m.requeued != 0 {
+				t.Errorf("%s: expected every vote to be finished once, got %+v", test.name, m)
+			}
+		}
+		for name, want := range test.results {
+			p, err := polls.Get(ids[name])
+			if err != nil {
+				t.Fatal(err)
+			}
+			total := 0
+			for _, n := range want {
+				total += n
+			}
+			if len(p.Results) != len(want) || p.Total != total || (len(want) > 0 && !reflect.DeepEqual(p.Results, want)) {
+				t.Errorf("%s: expected %s to have %v, got %v (total %d)", test.name, name, want, p.Results, p.Total)
+			}
+		}
+		updates := pub.updates(t)
+		if test.updates == nil {
+			if len(updates) != 0 {
+				t.Errorf("%s: expected no update, got %d", test.name, len(updates))
+			}
+			continue
+		}
+		if len(updates) != 1 {
+			t.Errorf("%s: expected one update, got %d", test.name, len(updates))
+			continue
+		}
+		got := make(map[string]map[string]int)
+		for id, counts := range updates[0].Counts {
+			got[names[id]] = counts
+		}
+		if !reflect.DeepEqual(got, test.updates) {
+			t.Errorf("%s: expected update %v, got %v", test.name, test.updates, got)
+		}
+	}
+}
+
+func TestDoCountIgnoresBatchesAlreadyWritten(t *testing.T) {
+	polls := poll.NewMemoryStore()
+	id := createPoll(t, polls, poll.Open, "a", "b")
+	c := New(polls, nil)
+	castVote(t, c, id, "a", time.Now())
+	c.doCount()
+	p, err := polls.Get(id)
+	if err != nil || len(p.Batches) != 1 {
+		t.Fatalf("expected the poll to hold the batch, got %v, %v", p, err)
+	}
+	// a counter writing the batch again, as after a crash
+	// between the write and the acknowledgement
+	credited, err := polls.IncrementResults(p.Batches[0], time.Now(), map[string]map[string]int{id: {"a": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]map[string]int{id: {"a": 1}}; !reflect.DeepEqual(credited, want) {
+		t.Errorf("expected the batch to be credited again, got %v", credited)
+	}
+	if p, _ = polls.Get(id); p.Results["a"] != 1 || p.Total != 1 {
+		t.Errorf("expected the vote to be counted once, got %v (total %d)", p.Results, p.Total)
+	}
+}
+
+func TestDoCountCreditsVotesReceivedBeforeClosing(t *testing.T) {
+	store := &failingStore{MemoryStore: poll.NewMemoryStore(), failing: map[string]bool{}}
+	closesAt := time.Now().Add(50 * time.Millisecond)
+	p := &poll.Poll{Title: "test", Options: []string{"a"}, Status: poll.Open, ClosesAt: &closesAt}
+	if err := store.Create(p); err != nil {
+		t.Fatal(err)
+	}
+	id := p.ID.Hex()
+	c := New(store, nil)
+	m := castVote(t, c, id, "a", time.Now())
+	// the first attempt fails, and the poll closes before the retry
+	store.down = true
+	c.doCount()
+	store.down = false
+	time.Sleep(time.Until(closesAt) + 10*time.Millisecond)
+	c.doCount()
+	if m.finished != 1 {
+		t.Fatalf("expected the vote to be finished, got %+v", m)
+	}
+	if p, _ := store.Get(id); p.Results["a"] != 1 {
+		t.Errorf("expected the vote received before closing to count, got %v", p.Results)
+	}
+
+	// votes received once the poll closed do not count
+	castVote(t, c, id, "a", time.Now())
+	c.doCount()
+	if p, _ := store.Get(id); p.Results["a"] != 1 {
+		t.Errorf("expected the vote received after closing to be ignored, got %v", p.Results)
+	}
+}
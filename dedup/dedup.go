@@ -0,0 +1,95 @@
+// Package dedup keeps track of who voted for what, so that
+// each user only gets one vote per poll.
+package dedup
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Policy decides how repeated votes by the same user
+// in the same poll are treated.
+type Policy string
+
+const (
+	// None counts every vote. It is the default.
+	None Policy = "none"
+	// First gives each user one vote per poll; once they
+	// have voted, later votes are ignored.
+	First Policy = "first"
+	// Last gives each user one vote per poll; a later vote
+	// for another option replaces the earlier one.
+	Last Policy = "last"
+)
+
+// Valid reports whether p is a known policy.
+// The empty policy is valid and means None.
+func (p Policy) Valid() bool {
+	switch p {
+	case "", None, First, Last:
+		return true
+	}
+	return false
+}
+
+// Store records the vote of every user in every poll.
+type Store interface {
+	// Insert records the user's vote for option unless they
+	// already voted in the poll, reporting whether it did.
+	Insert(pollID, userID, option string) (bool, error)
+	// Replace records the user's vote for option, returning
+	// the option previously recorded, or "" if there was none.
+	Replace(pollID, userID, option string) (string, error)
+}
+
+// voter is a document in the store's collection.
+type voter struct {
+	ID     string `bson:"_id"`
+	Poll   string `bson:"poll"`
+	User   string `bson:"user"`
+	Option string `bson:"option"`
+}
+
+// MongoStore is a Store keeping votes in a MongoDB
+// collection, so they survive restarts.
+type MongoStore struct {
+	c *mgo.Collection
+}
+
+// NewMongoStore creates a Store using the collection.
+func NewMongoStore(c *mgo.Collection) *MongoStore {
+	return &MongoStore{c: c}
+}
+
+func voterID(pollID, userID string) string {
+	return pollID + "/" + userID
+}
+
+func (s *MongoStore) Insert(pollID, userID, option string) (bool, error) {
+	err := s.c.Insert(&voter{
+		ID:     voterID(pollID, userID),
+		Poll:   pollID,
+		User:   userID,
+		Option: option,
+	})
+	if mgo.IsDup(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *MongoStore) Replace(pollID, userID, option string) (string, error) {
+	var old voter
+	_, err := s.c.FindId(voterID(pollID, userID)).Apply(mgo.Change{
+		Update: bson.M{"$set": bson.M{
+			"poll":   pollID,
+			"user":   userID,
+			"option": option,
+		}},
+		Upsert: true,
+	}, &old)
+	if err != nil {
+		return "", err
+	}
+	return old.Option, nil
+}
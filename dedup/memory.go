@@ -0,0 +1,36 @@
+package dedup
+
+import "sync"
+
+// MemoryStore is a Store keeping votes in memory.
+// It is safe for use by many goroutines, but forgets
+// every vote when the process exits.
+type MemoryStore struct {
+	lock   sync.Mutex // protects voters
+	voters map[string]string
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{voters: make(map[string]string)}
+}
+
+func (s *MemoryStore) Insert(pollID, userID, option string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	id := voterID(pollID, userID)
+	if _, ok := s.voters[id]; ok {
+		return false, nil
+	}
+	s.voters[id] = option
+	return true, nil
+}
+
+func (s *MemoryStore) Replace(pollID, userID, option string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	id := voterID(pollID, userID)
+	previous := s.voters[id]
+	s.voters[id] = option
+	return previous, nil
+}
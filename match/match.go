@@ -0,0 +1,219 @@
+// Package match decides whether a piece of text, such as
+// the body of a tweet, mentions a poll option.
+package match
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Mode is the way an option is looked for in a text.
+type Mode string
+
+const (
+	// Word matches the option as one or more whole words,
+	// so "sad" matches "so sad!" but not "crusade".
+	// Options holding anything but words and spaces, such
+	// as "c++" or "don't", are matched as phrases instead,
+	// so their punctuation is not ignored.
+	// It is the default mode.
+	Word Mode = "word"
+	// Hashtag matches only the option used as a hashtag,
+	// so "happy" matches "#happy" but not "happy".
+	Hashtag Mode = "hashtag"
+	// Phrase matches the option verbatim, bounded by
+	// non-word characters on both sides.
+	Phrase Mode = "phrase"
+	// Regex matches a regular expression, given by the
+	// rule's pattern or, without one, the option itself.
+	Regex Mode = "regex"
+	// Contains matches the option anywhere in the text,
+	// including inside other words.
+	Contains Mode = "contains"
+)
+
+// Rule describes how an option is matched.
+// The zero value matches the option as a whole word,
+// ignoring case.
+type Rule struct {
+	Mode          Mode   `json:"mode,omitempty" bson:"mode,omitempty"`
+	Pattern       string `json:"pattern,omitempty" bson:"pattern,omitempty"`
+	CaseSensitive bool   `json:"caseSensitive,omitempty" bson:"casesensitive,omitempty"`
+}
+
+// Matcher reports whether a text mentions an option.
+type Matcher interface {
+	Match(text string) bool
+}
+
+// MatcherFunc is a function that implements Matcher.
+type MatcherFunc func(text string) bool
+
+// Match calls fn(text).
+func (fn MatcherFunc) Match(text string) bool {
+	return fn(text)
+}
+
+// New creates a Matcher for the option following the rule.
+func New(option string, r Rule) (Matcher, error) {
+	fold := foldString
+	if r.CaseSensitive {
+		fold = func(s string) string { return s }
+	}
+	switch r.Mode {
+	case Word, "":
+		want := words(fold(option))
+		if len(want) == 0 {
+			return nil, fmt.Errorf("match: option %q contains no words", option)
+		}
+		if phrase := fold(strings.Join(strings.Fields(option), " ")); strings.Join(want, " ") != phrase {
+			// punctuation would be dropped, so "c++" would match "c"
+			return phraseMatcher(phrase, fold), nil
+		}
+		return MatcherFunc(func(text string) bool {
+			return containsRun(words(fold(text)), want)
+		}), nil
+	case Hashtag:
+		tag := fold(strings.TrimPrefix(option, "#"))
+		if w := words(tag); len(w) != 1 || w[0] != tag {
+			return nil, fmt.Errorf("match: option %q is not a valid hashtag", option)
+		}
+		return MatcherFunc(func(text string) bool {
+			for _, t := range hashtags(fold(text)) {
+				if t == tag {
+					return true
+				}
+			}
+			return false
+		}), nil
+	case Phrase:
+		phrase := fold(strings.Join(strings.Fields(option), " "))
+		if phrase == "" {
+			return nil, fmt.Errorf("match: option %q is empty", option)
+		}
+		return phraseMatcher(phrase, fold), nil
+	case Regex:
+		pattern := r.Pattern
+		if pattern == "" {
+			pattern = option
+		}
+		if !r.CaseSensitive {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("match: invalid pattern for option %q: %v", option, err)
+		}
+		return MatcherFunc(re.MatchString), nil
+	case Contains:
+		sub := fold(option)
+		return MatcherFunc(func(text string) bool {
+			return strings.Contains(fold(text), sub)
+		}), nil
+	}
+	return nil, fmt.Errorf("match: unknown mode %q", r.Mode)
+}
+
+// phraseMatcher matches the phrase, already folded and with
+// its spaces collapsed, bounded by non-word characters.
+func phraseMatcher(phrase string, fold func(string) string) Matcher {
+	return MatcherFunc(func(text string) bool {
+		return containsBounded(fold(strings.Join(strings.Fields(text), " ")), phrase)
+	})
+}
+
+// isWordRune reports whether r may be part of a word.
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+// words splits s into its words, dropping everything else.
+func words(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !isWordRune(r)
+	})
+}
+
+// hashtags returns the hashtags in s, without the leading #.
+func hashtags(s string) []string {
+	var tags []string
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		if rs[i] != '#' || (i > 0 && isWordRune(rs[i-1])) {
+			continue
+		}
+		j := i + 1
+		for j < len(rs) && isWordRune(rs[j]) {
+			j++
+		}
+		if j > i+1 {
+			tags = append(tags, string(rs[i+1:j]))
+		}
+		i = j - 1
+	}
+	return tags
+}
+
+// containsRun reports whether want appears as
+// consecutive elements of have.
+func containsRun(have, want []string) bool {
+	for i := 0; i+len(want) <= len(have); i++ {
+		found := true
+		for j := range want {
+			if have[i+j] != want[j] {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// containsBounded reports whether sub appears in s without
+// a word character immediately before or after it.
+func containsBounded(s, sub string) bool {
+	for off := 0; off <= len(s)-len(sub); {
+		i := strings.Index(s[off:], sub)
+		if i < 0 {
+			return false
+		}
+		start, end := off+i, off+i+len(sub)
+		before, after := ' ', ' '
+		if start > 0 {
+			before = lastRune(s[:start])
+		}
+		if end < len(s) {
+			after = []rune(s[end:])[0]
+		}
+		if !isWordRune(before) && !isWordRune(after) {
+			return true
+		}
+		off = start + 1
+	}
+	return false
+}
+
+func lastRune(s string) rune {
+	rs := []rune(s)
+	return rs[len(rs)-1]
+}
+
+// foldString maps every rune of s to a canonical member of
+// its Unicode case folding orbit, so that strings differing
+// only in case fold to the same value.
+func foldString(s string) string {
+	return strings.Map(func(r rune) rune {
+		min := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if f < min {
+				min = f
+			}
+		}
+		return min
+	}, s)
+}
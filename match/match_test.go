@@ -0,0 +1,105 @@
+package match
+
+import "testing"
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		option  string
+		rule    Rule
+		matches []string
+		misses  []string
+	}{
+		{"word", "sad", Rule{},
+			[]string{"so sad!", "Sad.", "sad", "#sad", "(sad)", "sad_face? no: sad"},
+			[]string{"crusade", "sadness", "s a d", ""}},
+		{"words", "New York", Rule{Mode: Word},
+			[]string{"I love new york", "NEW   YORK!", "new-york"},
+			[]string{"york new", "newyork", "new yorker"}},
+		{"word ignoring case", "Straße", Rule{},
+			[]string{"STRASSE? no, straße", "STRAßE"},
+			[]string{"strasse"}},
+		{"word folding Unicode", "Ελλάδα", Rule{},
+			[]string{"ΕΛΛΆΔΑ", "στην ελλάδα"},
+			[]string{"ελλαδα"}},
+		{"word with accents", "café", Rule{},
+			[]string{"Café au lait", "CAFÉ!"},
+			[]string{"cafe", "cafés"}},
+		{"case sensitive word", "Go", Rule{CaseSensitive: true},
+			[]string{"I write Go", "Go!"},
+			[]string{"let's go", "GO"}},
+		{"punctuation", "c++", Rule{},
+			[]string{"I love c++", "C++!", "c++, java"},
+			[]string{"I love c!", "c", "c+ +"}},
+		{"apostrophe", "don't", Rule{},
+			[]string{"I don't know", "DON'T"},
+			[]string{"don t", "dont"}},
+		{"dotted", "node.js", Rule{},
+			[]string{"node.js rocks", "Node.JS"},
+			[]string{"node js", "nodejs", "node"}},
+		{"hashtag", "happy", Rule{Mode: Hashtag},
+			[]string{"#happy", "so #Happy!", "#sad #happy"},
+			[]string{"happy", "#happyness", "un#happy", "# happy"}},
+		{"hashtag given with #", "#Go", Rule{Mode: Hashtag},
+			[]string{"#go", "#GO"},
+			[]string{"go", "#golang"}},
+		{"case sensitive hashtag", "Go", Rule{Mode: Hashtag, CaseSensitive: true},
+			[]string{"#Go"},
+			[]string{"#go"}},
+		{"phrase", "very  happy", Rule{Mode: Phrase},
+			[]string{"I am Very happy!", "very\thappy"},
+			[]string{"very happyish", "everyvery happy", "very, happy"}},
+		{"phrase with punctuation", "c#", Rule{Mode: Phrase},
+			[]string{"I code in C#.", "c#"},
+			[]string{"c", "c #"}},
+		{"regex", "happy", Rule{Mode: Regex, Pattern: `hap+y`},
+			[]string{"happpy", "HAPY", "unhappy"},
+			[]string{"hay"}},
+		{"regex from the option", `^go\b`, Rule{Mode: Regex},
+			[]string{"Go home", "go"},
+			[]string{"ago", "let's go"}},
+		{"case sensitive regex", "x", Rule{Mode: Regex, Pattern: "Go", CaseSensitive: true},
+			[]string{"Go"},
+			[]string{"go"}},
+		{"contains", "sad", Rule{Mode: Contains},
+			[]string{"crusade", "SAD"},
+			[]string{"s-a-d"}},
+	}
+	for _, test := range tests {
+		m, err := New(test.option, test.rule)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		for _, text := range test.matches {
+			if !m.Match(text) {
+				t.Errorf("%s: expected %q to match %q", test.name, test.option, text)
+			}
+		}
+		for _, text := range test.misses {
+			if m.Match(text) {
+				t.Errorf("%s: expected %q not to match %q", test.name, test.option, text)
+			}
+		}
+	}
+}
+
+func TestNewRejectsInvalidRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		option string
+		rule   Rule
+	}{
+		{"no words", "!!!", Rule{}},
+		{"empty phrase", "  ", Rule{Mode: Phrase}},
+		{"hashtag of several words", "very happy", Rule{Mode: Hashtag}},
+		{"hashtag with punctuation", "c++", Rule{Mode: Hashtag}},
+		{"invalid pattern", "happy", Rule{Mode: Regex, Pattern: "hap(py"}},
+		{"unknown mode", "happy", Rule{Mode: "fuzzy"}},
+	}
+	for _, test := range tests {
+		if _, err := New(test.option, test.rule); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
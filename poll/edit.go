@@ -0,0 +1,93 @@
+package poll
+
+import (
+	"errors"
+	"reflect"
+	"socialpoll/dedup"
+)
+
+// ErrArchived is returned when editing an archived poll.
+var ErrArchived = errors.New("poll: archived polls cannot change")
+
+// Edit prepares next to replace p, checking the changes
+// are allowed. The title and schedule may always change;
+// options, match rules, dedup policy and filter only while
+// the poll is a draft, except that options may be removed
+// at any time. The status only changes through transitions.
+// Fields clients do not set, such as the results, are
+// copied from p, and match rules of removed options dropped.
+// Edit returns ErrArchived for archived polls, and a
+// ValidationError if next is invalid.
+func (p *Poll) Edit(next *Poll) error {
+	if p.Status.Normalize() == Archived {
+		return ErrArchived
+	}
+	next.ID = p.ID
+	next.Owner = p.Owner
+	next.APIKey = p.APIKey
+	next.Results = p.Results
+	next.Total = p.Total
+	next.ArchivedResults = p.ArchivedResults
+	next.Version = p.Version
+	next.Batches = p.Batches
+	for option := range next.Match {
+		if containsString(p.Options, option) && !containsString(next.Options, option) {
+			delete(next.Match, option)
+		}
+	}
+
+	var v validator
+	if next.Status != "" && next.Status.Normalize() != p.Status.Normalize() {
+		v.add("status", CodeImmutable, "status changes through open, close and archive")
+	}
+	next.Status = p.Status
+	if p.Status.Normalize() != Draft {
+		for i, option := range next.Options {
+			if !containsString(p.Options, option) {
+				v.add(optionField(i), CodeImmutable, "options can only be added to draft polls")
+			}
+		}
+		for _, option := range next.Options {
+			if !reflect.DeepEqual(p.Match[option], next.Match[option]) {
+				v.add("match."+option, CodeImmutable, "match rules can only change in draft polls")
+			}
+		}
+		if normalDedup(p.Dedup) != normalDedup(next.Dedup) {
+			v.add("dedup", CodeImmutable, "dedup policy can only change in draft polls")
+		}
+		if p.Filter != next.Filter {
+			v.add("filter", CodeImmutable, "filter can only change in draft polls")
+		}
+	}
+	if len(v) > 0 {
+		return ValidationError(v)
+	}
+	return next.Validate()
+}
+
+// RemovedOptions returns the options of p missing from next.
+func (p *Poll) RemovedOptions(next *Poll) []string {
+	var removed []string
+	for _, option := range p.Options {
+		if !containsString(next.Options, option) {
+			removed = append(removed, option)
+		}
+	}
+	return removed
+}
+
+func normalDedup(d dedup.Policy) dedup.Policy {
+	if d == "" {
+		return dedup.None
+	}
+	return d
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,304 @@
+package poll
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"socialpoll/match"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store keeping polls in memory.
+// It is safe for use by many goroutines, but polls are
+// lost when the process exits and are not shared with
+// other processes, so it is only meant for tests and
+// single process demos.
+type MemoryStore struct {
+	lock  sync.RWMutex // protects polls
+	polls map[string]*Poll
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{polls: make(map[string]*Poll)}
+}
+
+// clone returns a deep copy of p, so callers never
+// share state with the store.
+func clone(p *Poll) *Poll {
+	c := *p
+	c.Options = append([]string(nil), p.Options...)
+	c.Batches = append([]string(nil), p.Batches...)
+	if p.OpensAt != nil {
+		t := *p.OpensAt
+		c.OpensAt = &t
+	}
+	if p.ClosesAt != nil {
+		t := *p.ClosesAt
+		c.ClosesAt = &t
+	}
+	c.Results = cloneCounts(p.Results)
+	c.ArchivedResults = cloneCounts(p.ArchivedResults)
+	if p.Match != nil {
+		c.Match = make(map[string]match.Rule, len(p.Match))
+		for option, rule := range p.Match {
+			c.Match[option] = rule
+		}
+	}
+	return &c
+}
+
+func cloneCounts(counts map[string]int) map[string]int {
+	if counts == nil {
+		return nil
+	}
+	c := make(map[string]int, len(counts))
+	for option, count := range counts {
+		c[option] = count
+	}
+	return c
+}
+
+func (s *MemoryStore) List(q Query) ([]*Poll, error) {
+	var after *Poll
+	if q.After != "" {
+		total, id, err := q.cursor()
+		if err != nil {
+			return nil, err
+		}
+		after = &Poll{ID: id, Total: total}
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	result := make([]*Poll, 0, len(s.polls))
+	for _, p := range s.polls {
+		if q.matches(p) && (after == nil || q.less(after, p)) {
+			result = append(result, clone(p))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return q.less(result[i], result[j])
+	})
+	if q.Limit > 0 && len(result) > q.Limit {
+		result = result[:q.Limit]
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) Get(id string) (*Poll, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	p, ok := s.polls[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return clone(p), nil
+}
+
+func (s *MemoryStore) Create(p *Poll) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	p.ID = bson.NewObjectId()
+	s.polls[p.ID.Hex()] = clone(p)
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.polls[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.polls, id)
+	return nil
+}
+
+func (s *MemoryStore) IncrementResults(batch string, at time.Time, counts map[string]map[string]int) (map[string]map[string]int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	credited := make(map[string]map[string]int)
+	for id, options := range counts {
+		p, ok := s.polls[id]
+		if !ok {
+			continue
+		}
+		if containsString(p.Batches, batch) {
+			credited[id] = options
+			continue
+		}
+		if !p.Accepting(at) {
+			continue
+		}
+		p.Batches = append(p.Batches, batch)
+		if len(p.Batches) > maxBatches {
+			p.Batches = p.Batches[len(p.Batches)-maxBatches:]
+		}
+		if p.Results == nil {
+			p.Results = make(map[string]int)
+		}
+		for option, count := range options {
+			p.Results[option] += count
+			p.Total += count
+		}
+		credited[id] = options
+	}
+	return credited, nil
+}
+
+func (s *MemoryStore) ActiveOptions() ([]*Poll, error) {
+	all, err := s.List(Query{})
+	if err != nil {
+		return nil, err
+	}
+	var result []*Poll
+	now := time.Now()
+	for _, p := range all {
+		if p.Accepting(now) {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) Usage(owner string) (polls, options int, err error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, p := range s.polls {
+		if p.Owner == owner {
+			polls++
+			options += len(p.Options)
+		}
+	}
+	return polls, options, nil
+}
+
+func (s *MemoryStore) SetStatus(id string, from, to Status) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	p, ok := s.polls[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if p.Status.Normalize() != from.Normalize() {
+		return ErrConflict
+	}
+	p.Status = to
+	p.Version++
+	return nil
+}
+
+func (s *MemoryStore) Update(p *Poll, version int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	old, ok := s.polls[p.ID.Hex()]
+	if !ok {
+		return ErrNotFound
+	}
+	if old.Version != version {
+		return ErrConflict
+	}
+	results := old.Results
+	archived := old.ArchivedResults
+	for _, option := range old.RemovedOptions(p) {
+		if count, ok := results[option]; ok {
+			if archived == nil {
+				archived = make(map[string]int)
+			}
+			archived[option] = count
+			delete(results, option)
+		}
+	}
+	updated := clone(p)
+	updated.Status = old.Status
+	updated.Batches = old.Batches
+	updated.Results = results
+	updated.ArchivedResults = archived
+	updated.Total = old.Total
+	updated.Version = version + 1
+	s.polls[p.ID.Hex()] = updated
+	p.Version = updated.Version
+	return nil
+}
+
+// MemoryTimelineStore is a TimelineStore keeping buckets in
+// memory, with the same caveats as MemoryStore.
+type MemoryTimelineStore struct {
+	lock    sync.Mutex // protects buckets
+	buckets map[memoryBucketKey]*memoryBucket
+}
+
+type memoryBucketKey struct {
+	poll       string
+	resolution Resolution
+	time       time.Time
+}
+
+type memoryBucket struct {
+	counts  map[string]int
+	batches []string
+}
+
+// NewMemoryTimelineStore creates an empty MemoryTimelineStore.
+func NewMemoryTimelineStore() *MemoryTimelineStore {
+	return &MemoryTimelineStore{buckets: make(map[memoryBucketKey]*memoryBucket)}
+}
+
+func (s *MemoryTimelineStore) AddHistory(batch string, h History) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, r := range Resolutions {
+		for id, buckets := range h.buckets(r) {
+			for t, counts := range buckets {
+				key := memoryBucketKey{poll: id, resolution: r, time: t}
+				b, ok := s.buckets[key]
+				if !ok {
+					b = &memoryBucket{counts: make(map[string]int)}
+					s.buckets[key] = b
+				}
+				if containsString(b.batches, batch) {
+					continue
+				}
+				b.batches = append(b.batches, batch)
+				if len(b.batches) > maxBatches {
+					b.batches = b.batches[len(b.batches)-maxBatches:]
+				}
+				for option, count := range counts {
+					b.counts[option] += count
+				}
+			}
+		}
+	}
+	s.expire(time.Now())
+	return nil
+}
+
+// expire drops the buckets past their retention.
+func (s *MemoryTimelineStore) expire(now time.Time) {
+	for key := range s.buckets {
+		retention := key.resolution.Retention()
+		if retention > 0 && now.After(key.time.Add(key.resolution.Duration()+retention)) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func (s *MemoryTimelineStore) Timeline(id string, r Resolution, from, to time.Time) ([]Bucket, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var result []Bucket
+	from = from.UTC().Truncate(r.Duration())
+	for key, b := range s.buckets {
+		if key.poll != id || key.resolution != r || key.time.Before(from) || !key.time.Before(to) {
+			continue
+		}
+		counts := make(map[string]int, len(b.counts))
+		for option, count := range b.counts {
+			counts[option] = count
+		}
+		result = append(result, Bucket{Time: key.time, Counts: counts})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+	return result, nil
+}
@@ -0,0 +1,463 @@
+package poll
+
+import (
+	"fmt"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"regexp"
+	"time"
+)
+
+// MongoStore is a Store keeping polls in a MongoDB collection.
+// Each call works on its own copy of the session, so a
+// MongoStore may be used by many goroutines.
+type MongoStore struct {
+	session    *mgo.Session
+	database   string
+	collection string
+}
+
+// NewMongoStore creates a Store keeping polls in the named
+// database and collection of the session.
+func NewMongoStore(session *mgo.Session, database, collection string) *MongoStore {
+	return &MongoStore{
+		session:    session,
+		database:   database,
+		collection: collection,
+	}
+}
+
+// polls returns the collection along with a function
+// closing the session it uses.
+func (s *MongoStore) polls() (*mgo.Collection, func()) {
+	session := s.session.Copy()
+	return session.DB(s.database).C(s.collection), session.Close
+}
+
+// objectID converts a hex ID, returning ErrNotFound
+// for IDs that cannot belong to any poll.
+func objectID(id string) (bson.ObjectId, error) {
+	if !bson.IsObjectIdHex(id) {
+		return "", ErrNotFound
+	}
+	return bson.ObjectIdHex(id), nil
+}
+
+// statusSelector selects the polls with the given status,
+// including, for open, those created before statuses existed.
+func statusSelector(s Status) interface{} {
+	if s.Normalize() == Open {
+		return bson.M{"$in": []interface{}{Open, nil}}
+	}
+	return s
+}
+
+// acceptingSelector selects the polls accepting votes at t.
+func acceptingSelector(t time.Time) bson.M {
+	return bson.M{
+		"status": statusSelector(Open),
+		"$and": []bson.M{
+			{"$or": []bson.M{{"opensat": nil}, {"opensat": bson.M{"$lte": t}}}},
+			{"$or": []bson.M{{"closesat": nil}, {"closesat": bson.M{"$gt": t}}}},
+		},
+	}
+}
+
+func notFound(err error) error {
+	if err == mgo.ErrNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *MongoStore) List(q Query) ([]*Poll, error) {
+	var conds []bson.M
+	if q.Owner != "" {
+		conds = append(conds, bson.M{"owner": q.Owner})
+	}
+	if q.Status != "" {
+		conds = append(conds, bson.M{"status": statusSelector(q.Status)})
+	}
+	if q.Title != "" {
+		conds = append(conds, bson.M{"title": bson.RegEx{
+			Pattern: regexp.QuoteMeta(q.Title),
+			Options: "i",
+		}})
+	}
+	// object IDs start with their creation time
+	if !q.CreatedFrom.IsZero() {
+		conds = append(conds, bson.M{"_id": bson.M{"$gte": bson.NewObjectIdWithTime(q.CreatedFrom)}})
+	}
+	if !q.CreatedTo.IsZero() {
+		conds = append(conds, bson.M{"_id": bson.M{"$lt": bson.NewObjectIdWithTime(q.CreatedTo)}})
+	}
+	if q.After != "" {
+		total, id, err := q.cursor()
+		if err != nil {
+			return nil, err
+		}
+		cmp := "$gt"
+		if q.Sort.descending() {
+			cmp = "$lt"
+		}
+		if q.Sort.byVotes() {
+			conds = append(conds, bson.M{"$or": []bson.M{
+				{"total": bson.M{cmp: total}},
+				{"total": total, "_id": bson.M{cmp: id}},
+			}})
+		} else {
+			conds = append(conds, bson.M{"_id": bson.M{cmp: id}})
+		}
+	}
+	var sel bson.M
+	if len(conds) > 0 {
+		sel = bson.M{"$and": conds}
+	}
+	var sort []string
+	switch q.Sort {
+	case ByCreatedDesc:
+		sort = []string{"-_id"}
+	case ByVotes:
+		sort = []string{"total", "_id"}
+	case ByVotesDesc:
+		sort = []string{"-total", "-_id"}
+	default:
+		sort = []string{"_id"}
+	}
+
+	c, done := s.polls()
+	defer done()
+	var result []*Poll
+	if err := c.Find(sel).Sort(sort...).Limit(q.Limit).All(&result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *MongoStore) Get(id string) (*Poll, error) {
+	oid, err := objectID(id)
+	if err != nil {
+		return nil, err
+	}
+	c, done := s.polls()
+	defer done()
+	var p Poll
+	if err := c.FindId(oid).One(&p); err != nil {
+		return nil, notFound(err)
+	}
+	return &p, nil
+}
+
+func (s *MongoStore) Create(p *Poll) error {
+	c, done := s.polls()
+	defer done()
+	p.ID = bson.NewObjectId()
+	return c.Insert(p)
+}
+
+func (s *MongoStore) Delete(id string) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	c, done := s.polls()
+	defer done()
+	return notFound(c.RemoveId(oid))
+}
+
+// IncrementResults updates all polls of the batch
+// in a single round trip, then finds those holding it
+// in another.
+func (s *MongoStore) IncrementResults(batch string, at time.Time, counts map[string]map[string]int) (map[string]map[string]int, error) {
+	c, done := s.polls()
+	defer done()
+	bulk := c.Bulk()
+	bulk.Unordered()
+	var ids []bson.ObjectId
+	for id, options := range counts {
+		if !bson.IsObjectIdHex(id) || len(options) == 0 {
+			continue
+		}
+		inc := make(bson.M, len(options)+1)
+		total := 0
+		for option, count := range options {
+			inc["results."+option] = count
+			total += count
+		}
+		inc["total"] = total
+		sel := acceptingSelector(at)
+		sel["_id"] = bson.ObjectIdHex(id)
+		sel["batches"] = bson.M{"$ne": batch}
+		bulk.Update(sel, bson.M{
+			"$inc": inc,
+			"$push": bson.M{"batches": bson.M{
+				"$each":  []string{batch},
+				"$slice": -maxBatches,
+			}},
+		})
+		ids = append(ids, bson.ObjectIdHex(id))
+	}
+	credited := make(map[string]map[string]int)
+	if len(ids) == 0 {
+		return credited, nil
+	}
+	if _, err := bulk.Run(); err != nil {
+		return nil, err
+	}
+	// polls that were not accepting votes, or are gone,
+	// do not hold the batch
+	var held []struct {
+		ID bson.ObjectId `bson:"_id"`
+	}
+	err := c.Find(bson.M{
+		"_id":     bson.M{"$in": ids},
+		"batches": batch,
+	}).Select(bson.M{"_id": 1}).All(&held)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range held {
+		credited[p.ID.Hex()] = counts[p.ID.Hex()]
+	}
+	return credited, nil
+}
+
+// EnsureIndexes creates the indexes the store relies on.
+func (s *MongoStore) EnsureIndexes() error {
+	c, done := s.polls()
+	defer done()
+	if err := c.EnsureIndexKey("options"); err != nil {
+		return err
+	}
+	// counting the polls of owners for their quotas
+	if err := c.EnsureIndexKey("owner"); err != nil {
+		return err
+	}
+	return c.EnsureIndexKey("total", "_id")
+}
+
+func (s *MongoStore) ActiveOptions() ([]*Poll, error) {
+	c, done := s.polls()
+	defer done()
+	var result []*Poll
+	err := c.Find(acceptingSelector(time.Now())).Select(bson.M{
+		"options": 1,
+		"match":   1,
+		"dedup":   1,
+		"filter":  1,
+	}).All(&result)
+	return result, err
+}
+
+func (s *MongoStore) Usage(owner string) (polls, options int, err error) {
+	c, done := s.polls()
+	defer done()
+	var result []struct {
+		Polls   int `bson:"polls"`
+		Options int `bson:"options"`
+	}
+	err = c.Pipe([]bson.M{
+		{"$match": bson.M{"owner": owner}},
+		{"$group": bson.M{
+			"_id":     nil,
+			"polls":   bson.M{"$sum": 1},
+			"options": bson.M{"$sum": bson.M{"$size": bson.M{"$ifNull": []interface{}{"$options", []string{}}}}},
+		}},
+	}).All(&result)
+	if err != nil || len(result) == 0 {
+		return 0, 0, err
+	}
+	return result[0].Polls, result[0].Options, nil
+}
+
+func (s *MongoStore) SetStatus(id string, from, to Status) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	c, done := s.polls()
+	defer done()
+	err = c.Update(bson.M{"_id": oid, "status": statusSelector(from)},
+		bson.M{"$set": bson.M{"status": to}, "$inc": bson.M{"version": 1}})
+	if err == mgo.ErrNotFound {
+		n, err := c.FindId(oid).Count()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrNotFound
+		}
+		return ErrConflict
+	}
+	return err
+}
+
+// versionSelector selects the polls with the given version,
+// including, for 0, those created before versions existed.
+func versionSelector(version int) interface{} {
+	if version == 0 {
+		return bson.M{"$in": []interface{}{0, nil}}
+	}
+	return version
+}
+
+func (s *MongoStore) Update(p *Poll, version int) error {
+	c, done := s.polls()
+	defer done()
+	sel := bson.M{"_id": p.ID, "version": versionSelector(version)}
+	var old Poll
+	err := c.Find(sel).Select(bson.M{"options": 1}).One(&old)
+	if err == mgo.ErrNotFound {
+		n, err := c.FindId(p.ID).Count()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrNotFound
+		}
+		return ErrConflict
+	}
+	if err != nil {
+		return err
+	}
+
+	set := bson.M{
+		"title":   p.Title,
+		"options": p.Options,
+		"filter":  p.Filter,
+	}
+	unset := bson.M{}
+	setOrUnset := func(key string, value interface{}, ok bool) {
+		if ok {
+			set[key] = value
+		} else {
+			unset[key] = ""
+		}
+	}
+	setOrUnset("match", p.Match, len(p.Match) > 0)
+	setOrUnset("dedup", p.Dedup, p.Dedup != "")
+	setOrUnset("opensat", p.OpensAt, p.OpensAt != nil)
+	setOrUnset("closesat", p.ClosesAt, p.ClosesAt != nil)
+	update := bson.M{
+		"$set": set,
+		"$inc": bson.M{"version": 1},
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	// renaming is atomic, so no vote counted meanwhile is lost
+	rename := bson.M{}
+	for _, option := range old.RemovedOptions(p) {
+		rename["results."+option] = "archivedresults." + option
+	}
+	if len(rename) > 0 {
+		update["$rename"] = rename
+	}
+	if err := c.Update(sel, update); err != nil {
+		if err == mgo.ErrNotFound {
+			return ErrConflict
+		}
+		return err
+	}
+	p.Version = version + 1
+	return nil
+}
+
+// MongoTimelineStore is a TimelineStore keeping buckets in a
+// MongoDB collection, one document per poll, resolution and
+// bucket. Old buckets are removed by a TTL index.
+type MongoTimelineStore struct {
+	session    *mgo.Session
+	database   string
+	collection string
+}
+
+// NewMongoTimelineStore creates a TimelineStore keeping buckets
+// in the named database and collection of the session.
+func NewMongoTimelineStore(session *mgo.Session, database, collection string) *MongoTimelineStore {
+	return &MongoTimelineStore{
+		session:    session,
+		database:   database,
+		collection: collection,
+	}
+}
+
+func (s *MongoTimelineStore) buckets() (*mgo.Collection, func()) {
+	session := s.session.Copy()
+	return session.DB(s.database).C(s.collection), session.Close
+}
+
+// EnsureIndexes creates the indexes the store relies on.
+func (s *MongoTimelineStore) EnsureIndexes() error {
+	c, done := s.buckets()
+	defer done()
+	if err := c.EnsureIndexKey("poll", "resolution", "time"); err != nil {
+		return err
+	}
+	// documents expire at their expires time, a second
+	// being the smallest delay mgo lets us ask for
+	return c.EnsureIndex(mgo.Index{
+		Key:         []string{"expires"},
+		ExpireAfter: time.Second,
+	})
+}
+
+func (s *MongoTimelineStore) AddHistory(batch string, h History) error {
+	c, done := s.buckets()
+	defer done()
+	bulk := c.Bulk()
+	bulk.Unordered()
+	n := 0
+	for _, r := range Resolutions {
+		for id, buckets := range h.buckets(r) {
+			for t, counts := range buckets {
+				inc := make(bson.M, len(counts))
+				for option, count := range counts {
+					inc["counts."+option] = count
+				}
+				set := bson.M{"poll": id, "resolution": r, "time": t}
+				if retention := r.Retention(); retention > 0 {
+					set["expires"] = t.Add(r.Duration() + retention - time.Second)
+				}
+				// a bucket that already has the batch does not match,
+				// so the upsert fails on its ID, which means the batch
+				// was added already
+				bulk.Upsert(bson.M{
+					"_id":     fmt.Sprintf("%s/%s/%d", id, r, t.Unix()),
+					"batches": bson.M{"$ne": batch},
+				}, bson.M{
+					"$set": set,
+					"$inc": inc,
+					"$push": bson.M{"batches": bson.M{
+						"$each":  []string{batch},
+						"$slice": -maxBatches,
+					}},
+				})
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	_, err := bulk.Run()
+	if mgo.IsDup(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *MongoTimelineStore) Timeline(id string, r Resolution, from, to time.Time) ([]Bucket, error) {
+	c, done := s.buckets()
+	defer done()
+	var result []Bucket
+	err := c.Find(bson.M{
+		"poll":       id,
+		"resolution": r,
+		"time": bson.M{
+			"$gte": from.UTC().Truncate(r.Duration()),
+			"$lt":  to,
+		},
+	}).Sort("time").Select(bson.M{"time": 1, "counts": 1}).All(&result)
+	return result, err
+}
@@ -0,0 +1,77 @@
+// Package poll holds the poll model shared by all the
+// socialpoll commands, and the ways polls are stored.
+package poll
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"socialpoll/dedup"
+	"socialpoll/match"
+	"time"
+)
+
+// Poll is a question whose options are voted for
+// by mentioning them.
+type Poll struct {
+	ID      bson.ObjectId         `bson:"_id" json:"id"`
+	Title   string                `json:"title"`
+	Options []string              `json:"options"`
+	Results map[string]int        `json:"results,omitempty"`
+	Match   map[string]match.Rule `json:"match,omitempty" bson:"match,omitempty"`
+	Dedup   dedup.Policy          `json:"dedup,omitempty" bson:"dedup,omitempty"`
+	Filter  TweetFilter           `json:"filter"`
+	// Total counts every vote the poll received, including
+	// those for options since removed.
+	Total int `json:"total" bson:"total"`
+	// ArchivedResults holds the results of options
+	// removed from the poll.
+	ArchivedResults map[string]int `json:"archivedResults,omitempty" bson:"archivedresults,omitempty"`
+	// Owner is the owner of the key the poll was created
+	// with; only they and admins may see or change it.
+	Owner string `json:"owner,omitempty" bson:"owner,omitempty"`
+	// APIKey is the ID of the key the poll was created with.
+	APIKey string `json:"-"`
+	// Status is where the poll is in its lifecycle.
+	Status Status `json:"status" bson:"status,omitempty"`
+	// OpensAt and ClosesAt, when set, limit the
+	// time an open poll takes votes.
+	OpensAt  *time.Time `json:"opensAt,omitempty" bson:"opensat,omitempty"`
+	ClosesAt *time.Time `json:"closesAt,omitempty" bson:"closesat,omitempty"`
+	// Version counts the changes made to the poll, other
+	// than to its results, to detect conflicting edits.
+	Version int `json:"version" bson:"version"`
+	// Batches lists the last vote batches added to the results,
+	// so a batch is never added twice.
+	Batches []string `json:"-" bson:"batches,omitempty"`
+}
+
+// maxBatches is how many batch IDs a poll remembers.
+const maxBatches = 100
+
+// TweetFilter holds the settings deciding which tweets
+// may vote in a poll.
+type TweetFilter struct {
+	// ExcludeRetweets ignores retweets.
+	ExcludeRetweets bool `json:"excludeRetweets"`
+	// QuotingTextOnly matches quote tweets on their own
+	// text only, rather than including the quoted tweet.
+	QuotingTextOnly bool `json:"quotingTextOnly"`
+	// ExcludeReplies ignores replies.
+	ExcludeReplies bool `json:"excludeReplies"`
+}
+
+// Matchers creates the matchers for the options of the poll.
+// Options with a broken rule fall back to the default one.
+func (p *Poll) Matchers() map[string]match.Matcher {
+	matchers := make(map[string]match.Matcher, len(p.Options))
+	for _, option := range p.Options {
+		m, err := match.New(option, p.Match[option])
+		if err != nil {
+			m, err = match.New(option, match.Rule{})
+			if err != nil {
+				continue
+			}
+		}
+		matchers[option] = m
+	}
+	return matchers
+}
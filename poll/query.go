@@ -0,0 +1,126 @@
+package poll
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/mgo.v2/bson"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor is returned for cursors that were
+// not made by Cursor for the same sort order.
+var ErrInvalidCursor = errors.New("poll: invalid cursor")
+
+// Sort is the order polls are listed in.
+type Sort string
+
+const (
+	// ByCreated lists the oldest polls first. It is the default.
+	ByCreated Sort = "created"
+	// ByCreatedDesc lists the newest polls first.
+	ByCreatedDesc Sort = "-created"
+	// ByVotes lists the polls with the fewest votes first.
+	ByVotes Sort = "votes"
+	// ByVotesDesc lists the polls with the most votes first.
+	ByVotesDesc Sort = "-votes"
+)
+
+// ParseSort returns the sort order with the given name.
+func ParseSort(s string) (Sort, error) {
+	switch o := Sort(s); o {
+	case ByCreated, ByCreatedDesc, ByVotes, ByVotesDesc:
+		return o, nil
+	}
+	return "", fmt.Errorf("poll: unknown sort order %q", s)
+}
+
+func (o Sort) byVotes() bool {
+	return o == ByVotes || o == ByVotesDesc
+}
+
+func (o Sort) descending() bool {
+	return strings.HasPrefix(string(o), "-")
+}
+
+// Query selects the polls to list, and their order.
+// The zero Query lists every poll, oldest first.
+type Query struct {
+	// Owner, when set, selects the polls of one owner.
+	Owner string
+	// Status, when set, selects the polls with the status.
+	Status Status
+	// Title, when set, selects the polls whose title
+	// contains it, ignoring case.
+	Title string
+	// CreatedFrom and CreatedTo, when set, select the
+	// polls created in [CreatedFrom, CreatedTo).
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// Sort is the order of the polls.
+	Sort Sort
+	// After, when set, is the cursor of the poll the list
+	// starts after, as returned by Cursor.
+	After string
+	// Limit, when positive, is the most polls listed.
+	Limit int
+}
+
+// Cursor returns the cursor to list the polls after p,
+// in the order of q.
+func (q *Query) Cursor(p *Poll) string {
+	if q.Sort.byVotes() {
+		return strconv.Itoa(p.Total) + "." + p.ID.Hex()
+	}
+	return p.ID.Hex()
+}
+
+// cursor parses the After cursor of q.
+func (q *Query) cursor() (total int, id bson.ObjectId, err error) {
+	s := q.After
+	if q.Sort.byVotes() {
+		i := strings.Index(s, ".")
+		if i < 0 {
+			return 0, "", ErrInvalidCursor
+		}
+		if total, err = strconv.Atoi(s[:i]); err != nil {
+			return 0, "", ErrInvalidCursor
+		}
+		s = s[i+1:]
+	}
+	if !bson.IsObjectIdHex(s) {
+		return 0, "", ErrInvalidCursor
+	}
+	return total, bson.ObjectIdHex(s), nil
+}
+
+// matches reports whether q selects p, ignoring the cursor.
+func (q *Query) matches(p *Poll) bool {
+	if q.Owner != "" && p.Owner != q.Owner {
+		return false
+	}
+	if q.Status != "" && p.Status.Normalize() != q.Status.Normalize() {
+		return false
+	}
+	if q.Title != "" && !strings.Contains(strings.ToLower(p.Title), strings.ToLower(q.Title)) {
+		return false
+	}
+	created := p.ID.Time()
+	if !q.CreatedFrom.IsZero() && created.Before(q.CreatedFrom) {
+		return false
+	}
+	if !q.CreatedTo.IsZero() && !created.Before(q.CreatedTo) {
+		return false
+	}
+	return true
+}
+
+// less reports whether a comes before b in the order of q.
+func (q *Query) less(a, b *Poll) bool {
+	if q.Sort.byVotes() && a.Total != b.Total {
+		return (a.Total < b.Total) != q.Sort.descending()
+	}
+	// object IDs start with their creation time
+	return (a.ID < b.ID) != q.Sort.descending()
+}
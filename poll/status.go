@@ -0,0 +1,72 @@
+package poll
+
+import (
+	"fmt"
+	"time"
+)
+
+// Status is the stage of its lifecycle a poll is in.
+type Status string
+
+const (
+	// Draft polls are being prepared and take no votes.
+	Draft Status = "draft"
+	// Open polls take votes between their opening and
+	// closing times, if they have any.
+	Open Status = "open"
+	// Closed polls take no more votes.
+	Closed Status = "closed"
+	// Archived polls are kept for the record only.
+	Archived Status = "archived"
+)
+
+// transitions lists the statuses a poll may move
+// to from each status.
+var transitions = map[Status][]Status{
+	Draft:  {Open, Archived},
+	Open:   {Closed},
+	Closed: {Open, Archived},
+}
+
+// ParseStatus returns the status with the given name.
+func ParseStatus(s string) (Status, error) {
+	switch st := Status(s); st {
+	case Draft, Open, Closed, Archived:
+		return st, nil
+	}
+	return "", fmt.Errorf("poll: unknown status %q", s)
+}
+
+// Normalize returns the status, taking polls created
+// before statuses existed, which have none, as open.
+func (s Status) Normalize() Status {
+	if s == "" {
+		return Open
+	}
+	return s
+}
+
+// CanBecome reports whether a poll may move from
+// status s to status to.
+func (s Status) CanBecome(to Status) bool {
+	for _, t := range transitions[s.Normalize()] {
+		if t == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Accepting reports whether the poll takes votes cast at t.
+func (p *Poll) Accepting(t time.Time) bool {
+	if p.Status.Normalize() != Open {
+		return false
+	}
+	if p.OpensAt != nil && t.Before(*p.OpensAt) {
+		return false
+	}
+	if p.ClosesAt != nil && !t.Before(*p.ClosesAt) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,54 @@
+package poll
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by a Store when
+// the requested poll does not exist.
+var ErrNotFound = errors.New("poll: not found")
+
+// ErrConflict is returned by a Store when a poll
+// was changed since it was read.
+var ErrConflict = errors.New("poll: changed by someone else")
+
+// Store keeps polls.
+// Polls are identified by the hex form of their ID.
+type Store interface {
+	// List returns the polls selected by q, in its order,
+	// or ErrInvalidCursor if its cursor cannot be parsed.
+	List(q Query) ([]*Poll, error)
+	// Get returns the poll with the given ID.
+	Get(id string) (*Poll, error)
+	// Create stores a new poll, setting its ID.
+	Create(p *Poll) error
+	// Delete removes the poll with the given ID.
+	Delete(id string) error
+	// IncrementResults adds counts, keyed by poll ID and then
+	// by option, to the results of the polls, as the batch with
+	// the given ID. Adding the same batch to a poll again does
+	// nothing, so a failed batch can be retried as a whole.
+	// Counts for polls that do not exist or were not accepting
+	// votes at time at, when the votes were received, are
+	// ignored. IncrementResults returns the counts of the polls
+	// that hold the batch, whether it was added now or by an
+	// earlier attempt.
+	IncrementResults(batch string, at time.Time, counts map[string]map[string]int) (credited map[string]map[string]int, err error)
+	// ActiveOptions returns the polls currently accepting votes.
+	// Only the fields needed to recognise votes are set.
+	ActiveOptions() ([]*Poll, error)
+	// SetStatus moves the poll with the given ID from status
+	// from to status to, returning ErrConflict if its status
+	// is no longer from.
+	SetStatus(id string, from, to Status) error
+	// Update replaces the poll with p, as prepared by Edit, if
+	// the stored poll still has the given version, returning
+	// ErrConflict otherwise. The results of options p no longer
+	// has are moved to its archived results. Update sets the
+	// new version of p.
+	Update(p *Poll, version int) error
+	// Usage returns how many polls the owner has, and how many
+	// options they have between them.
+	Usage(owner string) (polls, options int, err error)
+}
@@ -0,0 +1,109 @@
+package poll
+
+import (
+	"fmt"
+	"time"
+)
+
+// Resolution is the width of the buckets of a timeline.
+type Resolution string
+
+const (
+	Minute Resolution = "minute"
+	Hour   Resolution = "hour"
+	Day    Resolution = "day"
+)
+
+// Resolutions lists every resolution timelines are kept at,
+// from the finest to the coarsest.
+var Resolutions = []Resolution{Minute, Hour, Day}
+
+// ParseResolution returns the resolution with the given name.
+func ParseResolution(s string) (Resolution, error) {
+	for _, r := range Resolutions {
+		if string(r) == s {
+			return r, nil
+		}
+	}
+	return "", fmt.Errorf("poll: unknown resolution %q", s)
+}
+
+// Duration returns the width of a bucket.
+func (r Resolution) Duration() time.Duration {
+	switch r {
+	case Minute:
+		return time.Minute
+	case Hour:
+		return time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// Retention returns how long buckets are kept,
+// or 0 if they are kept forever.
+func (r Resolution) Retention() time.Duration {
+	switch r {
+	case Minute:
+		return 48 * time.Hour
+	case Hour:
+		return 90 * 24 * time.Hour
+	}
+	return 0
+}
+
+// Bucket holds the votes a poll received during
+// a period starting at Time.
+type Bucket struct {
+	Time   time.Time      `json:"time" bson:"time"`
+	Counts map[string]int `json:"counts" bson:"counts"`
+}
+
+// History holds votes to add to timelines, keyed by poll ID,
+// then by the minute they were cast, then by option.
+type History map[string]map[time.Time]map[string]int
+
+// Add counts n votes for the option of the poll cast at t.
+func (h History) Add(id, option string, t time.Time, n int) {
+	minute := t.UTC().Truncate(time.Minute)
+	if h[id] == nil {
+		h[id] = make(map[time.Time]map[string]int)
+	}
+	if h[id][minute] == nil {
+		h[id][minute] = make(map[string]int)
+	}
+	h[id][minute][option] += n
+}
+
+// buckets returns the counts of h at the resolution,
+// keyed by poll ID then by bucket time.
+func (h History) buckets(r Resolution) map[string]map[time.Time]map[string]int {
+	result := make(map[string]map[time.Time]map[string]int, len(h))
+	for id, minutes := range h {
+		result[id] = make(map[time.Time]map[string]int)
+		for minute, counts := range minutes {
+			t := minute.Truncate(r.Duration())
+			if result[id][t] == nil {
+				result[id][t] = make(map[string]int)
+			}
+			for option, n := range counts {
+				result[id][t][option] += n
+			}
+		}
+	}
+	return result
+}
+
+// TimelineStore keeps the history of the results of polls,
+// at every resolution. Buckets are dropped once they are
+// older than the retention of their resolution.
+type TimelineStore interface {
+	// AddHistory adds the votes to the timelines of their polls,
+	// as the batch with the given ID. Adding the same batch to
+	// a bucket again does nothing, so a failed batch can be
+	// retried as a whole.
+	AddHistory(batch string, h History) error
+	// Timeline returns the buckets of the poll with the given ID
+	// at the resolution, from the one holding from to the one
+	// before to, in order. Buckets without votes are left out.
+	Timeline(id string, r Resolution, from, to time.Time) ([]Bucket, error)
+}
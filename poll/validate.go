@@ -0,0 +1,158 @@
+package poll
+
+import (
+	"fmt"
+	"socialpoll/match"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxTitleLength is the most characters a title may have.
+	MaxTitleLength = 200
+	// MaxOptions is the most options a poll may have.
+	MaxOptions = 20
+	// MaxOptionLength is the most bytes an option may have;
+	// Twitter does not track longer phrases.
+	MaxOptionLength = 60
+)
+
+// Codes of the problems a FieldError reports.
+const (
+	CodeRequired     = "required"
+	CodeTooLong      = "too_long"
+	CodeTooMany      = "too_many"
+	CodeDuplicate    = "duplicate"
+	CodeInvalidChars = "invalid_chars"
+	CodeInvalid      = "invalid"
+	CodeUnknown      = "unknown"
+	// CodeImmutable reports a field that may not
+	// change any more.
+	CodeImmutable = "immutable"
+)
+
+// FieldError describes what is wrong with one field of a poll.
+type FieldError struct {
+	// Field is the path of the field, such as "options[2]"
+	// or "match.happy".
+	Field string `json:"field"`
+	// Code names the problem, such as "too_long".
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationError lists everything wrong with a poll.
+type ValidationError []*FieldError
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, f := range e {
+		msgs[i] = f.Error()
+	}
+	return "poll: " + strings.Join(msgs, "; ")
+}
+
+// validator collects the problems found with a poll.
+type validator ValidationError
+
+func (v *validator) add(field, code, format string, args ...interface{}) {
+	*v = append(*v, &FieldError{
+		Field:   field,
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Validate checks that the poll can be voted on, returning
+// a ValidationError if it cannot.
+func (p *Poll) Validate() error {
+	var v validator
+	switch n := utf8.RuneCountInString(p.Title); {
+	case strings.TrimSpace(p.Title) == "":
+		v.add("title", CodeRequired, "missing title")
+	case n > MaxTitleLength:
+		v.add("title", CodeTooLong, "title is longer than %d characters", MaxTitleLength)
+	}
+	switch {
+	case len(p.Options) == 0:
+		v.add("options", CodeRequired, "missing options")
+	case len(p.Options) > MaxOptions:
+		v.add("options", CodeTooMany, "more than %d options", MaxOptions)
+	}
+	options := make(map[string]bool)
+	seen := make(map[string]bool)
+	for i, option := range p.Options {
+		field := optionField(i)
+		options[option] = true
+		if err := validOption(option); err != nil {
+			err.Field = field
+			v = append(v, err)
+			continue
+		}
+		// options only differing by case match the same tweets
+		if key := strings.ToLower(option); seen[key] {
+			v.add(field, CodeDuplicate, "option %q is given twice", option)
+		} else {
+			seen[key] = true
+		}
+	}
+	for option, rule := range p.Match {
+		field := "match." + option
+		if !options[option] {
+			v.add(field, CodeUnknown, "match rule given for unknown option %q", option)
+			continue
+		}
+		if _, err := match.New(option, rule); err != nil {
+			v.add(field, CodeInvalid, "%v", err)
+		}
+	}
+	if !p.Dedup.Valid() {
+		v.add("dedup", CodeUnknown, "unknown dedup policy %q", p.Dedup)
+	}
+	if _, err := ParseStatus(string(p.Status.Normalize())); err != nil {
+		v.add("status", CodeUnknown, "unknown status %q", p.Status)
+	}
+	if p.OpensAt != nil && p.ClosesAt != nil && !p.OpensAt.Before(*p.ClosesAt) {
+		v.add("closesAt", CodeInvalid, "poll must open before it closes")
+	}
+	if len(v) > 0 {
+		return ValidationError(v)
+	}
+	return nil
+}
+
+// optionField returns the path of the option at index i.
+func optionField(i int) string {
+	return fmt.Sprintf("options[%d]", i)
+}
+
+// validOption checks that option can be tracked on Twitter,
+// whose track parameter is a comma separated list of phrases
+// of at most 60 bytes, and stored as a key of the results in
+// MongoDB, where dots separate the fields of a path and a
+// leading $ names an operator.
+func validOption(option string) *FieldError {
+	switch {
+	case strings.TrimSpace(option) == "":
+		return &FieldError{Code: CodeRequired, Message: "empty option"}
+	case len(option) > MaxOptionLength:
+		return &FieldError{Code: CodeTooLong, Message: fmt.Sprintf("option %q is longer than %d bytes", option, MaxOptionLength)}
+	case strings.TrimSpace(option) != option:
+		return &FieldError{Code: CodeInvalidChars, Message: fmt.Sprintf("option %q starts or ends with spaces", option)}
+	case !utf8.ValidString(option):
+		return &FieldError{Code: CodeInvalidChars, Message: "option is not valid UTF-8"}
+	case strings.HasPrefix(option, "$"):
+		return &FieldError{Code: CodeInvalidChars, Message: fmt.Sprintf("option %q starts with '$'", option)}
+	}
+	for _, r := range option {
+		if r == ',' || r == '.' || unicode.IsControl(r) {
+			return &FieldError{Code: CodeInvalidChars, Message: fmt.Sprintf("option %q contains %q", option, r)}
+		}
+	}
+	return nil
+}
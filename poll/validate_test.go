@@ -0,0 +1,80 @@
+package poll
+
+import (
+	"socialpoll/match"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+	tests := []struct {
+		name string
+		poll Poll
+		// fields maps the fields expected to be wrong
+		// to their codes; nil when the poll is valid.
+		fields map[string]string
+	}{
+		{"valid", Poll{Title: "Mood", Options: []string{"happy", "sad", "so so", "#tag", "node js", "a$"}}, nil},
+		{"no title", Poll{Title: " ", Options: []string{"a"}}, map[string]string{"title": CodeRequired}},
+		{"long title", Poll{Title: strings.Repeat("é", MaxTitleLength+1), Options: []string{"a"}}, map[string]string{"title": CodeTooLong}},
+		{"no options", Poll{Title: "t"}, map[string]string{"options": CodeRequired}},
+		{"too many options", Poll{Title: "t", Options: strings.Split("a b c d e f g h i j k l m n o p q r s t u", " ")}, map[string]string{"options": CodeTooMany}},
+		{"empty option", Poll{Title: "t", Options: []string{"a", ""}}, map[string]string{"options[1]": CodeRequired}},
+		{"long option", Poll{Title: "t", Options: []string{strings.Repeat("a", MaxOptionLength+1)}}, map[string]string{"options[0]": CodeTooLong}},
+		{"spaces", Poll{Title: "t", Options: []string{" a"}}, map[string]string{"options[0]": CodeInvalidChars}},
+		{"comma", Poll{Title: "t", Options: []string{"a,b"}}, map[string]string{"options[0]": CodeInvalidChars}},
+		{"control", Poll{Title: "t", Options: []string{"a\tb"}}, map[string]string{"options[0]": CodeInvalidChars}},
+		{"invalid UTF-8", Poll{Title: "t", Options: []string{"a\xffb"}}, map[string]string{"options[0]": CodeInvalidChars}},
+		{"dot", Poll{Title: "t", Options: []string{"ok", "node.js"}}, map[string]string{"options[1]": CodeInvalidChars}},
+		{"trailing dot", Poll{Title: "t", Options: []string{"end."}}, map[string]string{"options[0]": CodeInvalidChars}},
+		{"leading dollar", Poll{Title: "t", Options: []string{"$x"}}, map[string]string{"options[0]": CodeInvalidChars}},
+		{"duplicate", Poll{Title: "t", Options: []string{"Happy", "happy"}}, map[string]string{"options[1]": CodeDuplicate}},
+		{"unknown match", Poll{Title: "t", Options: []string{"a"}, Match: map[string]match.Rule{"b": {}}}, map[string]string{"match.b": CodeUnknown}},
+		{"invalid match", Poll{Title: "t", Options: []string{"a"}, Match: map[string]match.Rule{"a": {Mode: "nope"}}}, map[string]string{"match.a": CodeInvalid}},
+		{"unknown dedup", Poll{Title: "t", Options: []string{"a"}, Dedup: "nope"}, map[string]string{"dedup": CodeUnknown}},
+		{"unknown status", Poll{Title: "t", Options: []string{"a"}, Status: "nope"}, map[string]string{"status": CodeUnknown}},
+		{"closes first", Poll{Title: "t", Options: []string{"a"}, OpensAt: &later, ClosesAt: &now}, map[string]string{"closesAt": CodeInvalid}},
+	}
+	for _, test := range tests {
+		err := test.poll.Validate()
+		if test.fields == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", test.name, err)
+			}
+			continue
+		}
+		verr, ok := err.(ValidationError)
+		if !ok {
+			t.Errorf("%s: expected a ValidationError, got %v", test.name, err)
+			continue
+		}
+		got := make(map[string]string)
+		for _, f := range verr {
+			got[f.Field] = f.Code
+		}
+		if len(got) != len(test.fields) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.fields, got)
+			continue
+		}
+		for field, code := range test.fields {
+			if got[field] != code {
+				t.Errorf("%s: expected %s for %s, got %v", test.name, code, field, got)
+			}
+		}
+	}
+}
+
+func TestEditRejectsStorageUnsafeOptions(t *testing.T) {
+	p := &Poll{Title: "t", Options: []string{"a"}, Status: Draft}
+	for _, option := range []string{"node.js", "$set"} {
+		next := &Poll{Title: "t", Options: []string{"a", option}}
+		err := p.Edit(next)
+		verr, ok := err.(ValidationError)
+		if !ok || len(verr) != 1 || verr[0].Field != "options[1]" || verr[0].Code != CodeInvalidChars {
+			t.Errorf("option %q: expected an invalid_chars error for options[1], got %v", option, err)
+		}
+	}
+}
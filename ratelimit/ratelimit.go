@@ -0,0 +1,121 @@
+// Package ratelimit limits how often clients may act, with a
+// token bucket per client: each request takes a token, and
+// tokens come back at a steady rate, up to the size of the
+// bucket. Clients may thus burst up to the size of the bucket,
+// and keep going at the rate.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit is the rate and burst size of a bucket.
+// The zero Limit allows everything.
+type Limit struct {
+	// Rate is how many tokens come back every second.
+	Rate float64
+	// Burst is the size of the bucket.
+	Burst int
+}
+
+// Unlimited reports whether l allows everything.
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0 || l.Burst <= 0
+}
+
+// Result tells whether a request was allowed, and how
+// the bucket stands afterwards.
+type Result struct {
+	Allowed bool
+	// Limit is the size of the bucket.
+	Limit int
+	// Remaining is how many tokens are left.
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is, when the request was denied, how long
+	// until a token is available.
+	RetryAfter time.Duration
+}
+
+// sweepInterval is how often full buckets are forgotten.
+const sweepInterval = 1 * time.Minute
+
+// Limiter holds the buckets of the clients, by key.
+// Full buckets are forgotten, so a Limiter only remembers
+// the clients that were recently active.
+type Limiter struct {
+	lock      sync.Mutex // protects the fields below
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	limit  Limit
+	at     time.Time // when tokens was computed
+}
+
+// New creates a Limiter without buckets.
+func New() *Limiter {
+	return &Limiter{buckets: make(map[string]*bucket)}
+}
+
+// refill adds the tokens that came back since b.at.
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.at).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed*b.limit.Rate)
+	}
+	b.at = now
+}
+
+// Allow takes a token from the bucket of the key, created
+// full with the limit if needed, reporting whether there was
+// one. Buckets whose limit changed are resized.
+func (l *Limiter) Allow(key string, limit Limit, now time.Time) Result {
+	if limit.Unlimited() {
+		return Result{Allowed: true}
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), limit: limit, at: now}
+		l.buckets[key] = b
+	}
+	b.refill(now)
+	if b.limit != limit {
+		b.limit = limit
+		b.tokens = math.Min(b.tokens, float64(limit.Burst))
+	}
+	r := Result{Limit: limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		r.Allowed = true
+	} else {
+		r.RetryAfter = seconds((1 - b.tokens) / limit.Rate)
+	}
+	r.Remaining = int(b.tokens)
+	r.Reset = seconds((float64(limit.Burst) - b.tokens) / limit.Rate)
+	return r
+}
+
+// sweep forgets the buckets that are full again.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
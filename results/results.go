@@ -0,0 +1,40 @@
+// Package results defines the messages counter publishes on
+// the "results-updated" topic once it has added a batch of
+// votes to the results, so that the API can push them to
+// the clients watching the polls.
+package results
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Update lists the votes a batch added to the results.
+type Update struct {
+	// Batch is the ID of the batch, as recorded by the
+	// polls it was added to.
+	Batch string `json:"batch"`
+	// Time is when the batch was written.
+	Time time.Time `json:"time"`
+	// Counts holds the votes added, by poll ID and option.
+	// Counts may be negative when votes were retracted.
+	Counts map[string]map[string]int `json:"counts"`
+}
+
+// Encode returns the wire representation of the update.
+func (u *Update) Encode() ([]byte, error) {
+	return json.Marshal(u)
+}
+
+// Decode reads an update from its wire representation.
+func Decode(b []byte) (*Update, error) {
+	var u Update
+	if err := json.Unmarshal(b, &u); err != nil {
+		return nil, err
+	}
+	if u.Batch == "" {
+		return nil, errors.New("results: missing batch")
+	}
+	return &u, nil
+}
@@ -0,0 +1,141 @@
+package twittervotes
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"socialpoll/vote"
+	"sync"
+	"time"
+)
+
+// ReplayOptions holds the settings of the replay source.
+type ReplayOptions struct {
+	// File is the newline delimited JSON file of tweets to read.
+	File string
+	// Speed divides the gaps between the recorded timestamps;
+	// 0 replays as fast as possible.
+	Speed float64
+	// Loop starts the file over once it is exhausted.
+	Loop bool
+}
+
+func init() {
+	registerSource("replay", newReplaySource)
+}
+
+// replaySource reads votes from a file of recorded tweets,
+// one JSON encoded tweet per line, so the rest of the system
+// can be exercised without access to Twitter.
+type replaySource struct {
+	tracker *Tracker
+
+	path  string
+	speed float64
+	loop  bool
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+func newReplaySource(tr *Tracker, opts *Options) (VoteSource, error) {
+	if opts.Replay.File == "" {
+		return nil, errors.New("the replay source needs a tweet file")
+	}
+	if opts.Replay.Speed < 0 {
+		return nil, errors.New("replay speed cannot be negative")
+	}
+	return &replaySource{
+		tracker:  tr,
+		path:     opts.Replay.File,
+		speed:    opts.Replay.Speed,
+		loop:     opts.Replay.Loop,
+		stopChan: make(chan struct{}),
+	}, nil
+}
+
+func (s *replaySource) Start(votes chan<- vote.Vote) <-chan struct{} {
+	stoppedchan := make(chan struct{}, 1)
+	go func() {
+		defer func() {
+			stoppedchan <- struct{}{}
+		}()
+		for {
+			log.Println("Replaying", s.path)
+			if err := s.replay(votes); err != nil {
+				log.Println("replay failed:", err)
+				return
+			}
+			if !s.loop || s.stopped() {
+				log.Println("stopping replay...")
+				return
+			}
+		}
+	}()
+	return stoppedchan
+}
+
+func (s *replaySource) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopChan)
+	})
+}
+
+func (s *replaySource) stopped() bool {
+	select {
+	case <-s.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// replay reads the file once, sending the votes found in each
+// tweet. When a speed is set, the gaps between the recorded
+// timestamps are honoured, divided by the speed.
+func (s *replaySource) replay(votes chan<- vote.Vote) error {
+	polls, err := s.tracker.loadPolls()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var last time.Time
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var t tweet
+		if err := json.Unmarshal(scanner.Bytes(), &t); err != nil {
+			log.Printf("%s:%d: skipping tweet: %v", s.path, line, err)
+			continue
+		}
+		if s.speed > 0 {
+			at, err := t.Time()
+			if err == nil {
+				if !last.IsZero() && at.After(last) {
+					wait := time.Duration(float64(at.Sub(last)) / s.speed)
+					select {
+					case <-s.stopChan:
+						return nil
+					case <-time.After(wait):
+					}
+				}
+				last = at
+			}
+		}
+		if s.stopped() {
+			return nil
+		}
+		s.tracker.sendMatches("replay", t, polls, votes)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,169 @@
+package twittervotes
+
+import (
+	"fmt"
+	"log"
+	"socialpoll/dedup"
+	"socialpoll/vote"
+	"sort"
+	"strings"
+	"time"
+)
+
+// VoteSource is anything votes can be read from, such as
+// the Twitter streaming API.
+// Sources decide which poll options are mentioned in the
+// messages they read and send a vote for each of them on the
+// votes channel, leaving it to publishVotes to pass them on.
+type VoteSource interface {
+	// Start begins reading votes in the background.
+	// The returned channel is signalled once the source
+	// has stopped and will no longer send on votes.
+	Start(votes chan<- vote.Vote) <-chan struct{}
+	// Stop asks the source to stop reading votes.
+	// It may be called more than once.
+	Stop()
+}
+
+// Options selects the vote sources and holds their settings.
+type Options struct {
+	// Sources is the comma separated list of the names
+	// of the sources to read votes from.
+	Sources string
+	// Replay holds the settings of the replay source.
+	Replay ReplayOptions
+}
+
+// sourceFactories holds a constructor for every known
+// source, keyed by the name used to select it.
+var sourceFactories = make(map[string]func(tr *Tracker, opts *Options) (VoteSource, error))
+
+// registerSource makes a source available for selection
+// by name.
+func registerSource(name string, fn func(tr *Tracker, opts *Options) (VoteSource, error)) {
+	if _, dup := sourceFactories[name]; dup {
+		panic("twittervotes: source registered twice: " + name)
+	}
+	sourceFactories[name] = fn
+}
+
+// SourceNames returns the names of all known sources.
+func SourceNames() []string {
+	var names []string
+	for name := range sourceFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newSources creates the sources named in opts, in the order
+// given, finding votes with tr.
+func newSources(opts *Options, tr *Tracker) ([]VoteSource, error) {
+	var sources []VoteSource
+	for _, name := range strings.Split(opts.Sources, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		fn, ok := sourceFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown vote source %q (available: %s)",
+				name, strings.Join(SourceNames(), ", "))
+		}
+		s, err := fn(tr, opts)
+		if err != nil {
+			return nil, fmt.Errorf("creating vote source %q: %v", name, err)
+		}
+		sources = append(sources, s)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no vote sources selected")
+	}
+	return sources, nil
+}
+
+// sendMatches sends a vote on the votes channel for every
+// poll option mentioned in the tweet read from source,
+// applying the tweet filter and dedup policy of each poll.
+func (tr *Tracker) sendMatches(source string, t tweet, polls []trackedPoll, votes chan<- vote.Vote) {
+	at, err := t.Time()
+	if err != nil {
+		at = time.Now()
+	}
+	for _, p := range polls {
+		text, ok := filterText(p.Filter, t)
+		if !ok {
+			continue
+		}
+		var options []string
+		for _, option := range p.Options {
+			if m := p.matchers[option]; m != nil && m.Match(text) {
+				options = append(options, option)
+			}
+		}
+		if len(options) == 0 {
+			continue
+		}
+		v := vote.Vote{
+			PollID:   p.ID.Hex(),
+			Source:   source,
+			SourceID: t.ID,
+			Time:     at,
+		}
+		for _, cast := range tr.dedupVotes(p.Dedup, v, t.User.ID, options) {
+			log.Println("vote:", cast.PollID, cast.Option, cast.Retract)
+			votes <- cast
+		}
+	}
+}
+
+// dedupVotes returns the votes to publish for a message by the user
+// mentioning options of a poll with the given dedup policy.
+// Unless every vote counts, messages mentioning more than one option
+// are ambiguous and messages without a user cannot be attributed,
+// so both are ignored.
+func (tr *Tracker) dedupVotes(policy dedup.Policy, v vote.Vote, userID string, options []string) []vote.Vote {
+	var result []vote.Vote
+	if policy == "" || policy == dedup.None {
+		for _, option := range options {
+			v.Option = option
+			result = append(result, v)
+		}
+		return result
+	}
+	if len(options) > 1 || userID == "" {
+		return nil
+	}
+	v.Option = options[0]
+	switch policy {
+	case dedup.First:
+		ok, err := tr.Voters.Insert(v.PollID, userID, v.Option)
+		if err != nil {
+			log.Println("failed to record voter:", err)
+			return nil
+		}
+		if ok {
+			result = append(result, v)
+		}
+	case dedup.Last:
+		previous, err := tr.Voters.Replace(v.PollID, userID, v.Option)
+		if err != nil {
+			log.Println("failed to record voter:", err)
+			return nil
+		}
+		if previous == v.Option {
+			return nil
+		}
+		if previous != "" {
+			retract := v
+			retract.Option = previous
+			retract.Retract = true
+			result = append(result, retract)
+		}
+		result = append(result, v)
+	default:
+		log.Println("ignoring votes for poll", v.PollID, "with unknown dedup policy:", policy)
+	}
+	return result
+}
@@ -1,4 +1,4 @@
-package main
+package twittervotes
 
 import (
 	"encoding/json"
@@ -9,26 +9,106 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"socialpoll/poll"
+	"socialpoll/vote"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
-var (
-	conn          net.Conn
-	reader        io.ReadCloser
-	authClient    *oauth.Client
-	creds         *oauth.Credentials
-	authSetupOnce sync.Once
-	httpClient    *http.Client
-)
+func init() {
+	registerSource("twitter", newTwitterSource)
+}
 
 type tweet struct {
-	Text string
+	ID        string `json:"id_str"`
+	Text      string
+	CreatedAt string `json:"created_at"`
+	User      struct {
+		ID string `json:"id_str"`
+	}
+	InReplyToStatusID string `json:"in_reply_to_status_id_str"`
+	RetweetedStatus   *tweet `json:"retweeted_status"`
+	QuotedStatus      *tweet `json:"quoted_status"`
 }
 
-func startTwitterStream(stopChan <-chan struct{}, votes chan<- string) <-chan struct{} {
+// filterText returns the text of t to match the options of a
+// poll with filter f against, or false if the tweet must not
+// vote at all.
+// The text of a quote tweet is followed by the quoted text,
+// unless only the quoting text counts.
+func filterText(f poll.TweetFilter, t tweet) (string, bool) {
+	if f.ExcludeRetweets && t.RetweetedStatus != nil {
+		return "", false
+	}
+	if f.ExcludeReplies && t.InReplyToStatusID != "" {
+		return "", false
+	}
+	if t.QuotedStatus != nil && !f.QuotingTextOnly {
+		return t.Text + "\n" + t.QuotedStatus.Text, true
+	}
+	return t.Text, true
+}
+
+// Time parses the time the tweet was created at.
+func (t tweet) Time() (time.Time, error) {
+	return time.Parse(time.RubyDate, t.CreatedAt)
+}
+
+// twitterSource reads votes from the Twitter streaming API,
+// tracking the options of every poll.
+type twitterSource struct {
+	tracker *Tracker
+
+	lock   sync.Mutex // protects conn and reader
+	conn   net.Conn
+	reader io.ReadCloser
+
+	authClient *oauth.Client
+	creds      *oauth.Credentials
+	httpClient *http.Client
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+// newTwitterSource reads the environment variables and
+// sets up the OAuth object needed in order to
+// authenticate requests.
+func newTwitterSource(tr *Tracker, opts *Options) (VoteSource, error) {
+	var ts struct {
+		ConsumerKey    string `env:"SP_TWITTER_KEY,required"`
+		ConsumerSecret string `env:"SP_TWITTER_SECRET,required"`
+		AccessToken    string `env:"SP_TWITTER_ACCESSTOKEN,required"`
+		AccessSecret   string `env:"SP_TWITTER_ACCESSSECRET,required"`
+	}
+	if err := envdecode.Decode(&ts); err != nil {
+		return nil, err
+	}
+	s := &twitterSource{
+		tracker: tr,
+		creds: &oauth.Credentials{
+			Token:  ts.AccessToken,
+			Secret: ts.AccessSecret,
+		},
+		authClient: &oauth.Client{
+			Credentials: oauth.Credentials{
+				Token:  ts.ConsumerKey,
+				Secret: ts.ConsumerSecret,
+			},
+		},
+		stopChan: make(chan struct{}),
+	}
+	s.httpClient = &http.Client{
+		Transport: &http.Transport{
+			Dial: s.dial,
+		},
+	}
+	return s, nil
+}
+
+func (s *twitterSource) Start(votes chan<- vote.Vote) <-chan struct{} {
 	stoppedchan := make(chan struct{}, 1)
 	go func() {
 		defer func() {
@@ -36,27 +116,51 @@ func startTwitterStream(stopChan <-chan struct{}, votes chan<- string) <-chan st
 		}()
 		for {
 			select {
-			case <-stopChan:
+			case <-s.stopChan:
 				log.Println("stopping Twitter...")
 				return
 			default:
 				log.Println("Querying Twitter...")
-				readFromTwitter(votes)
+				s.read(votes)
 				log.Println("    (waiting)")
-				time.Sleep(10 * time.Second)
+				select {
+				case <-s.stopChan:
+				case <-time.After(10 * time.Second):
+				}
+			}
+		}
+	}()
+
+	// This goroutine will call closeConn every minute
+	// causing the connection to die and cause
+	// read to be called all over again.
+	go func() {
+		for {
+			select {
+			case <-s.stopChan:
+				return
+			case <-time.After(1 * time.Minute):
+				s.closeConn()
 			}
 		}
 	}()
 	return stoppedchan
 }
 
-// readFromTwitter reloads the options from the database
+func (s *twitterSource) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopChan)
+		s.closeConn()
+	})
+}
+
+// read reloads the polls from the database
 // each time it is called so the the program is updated without
 // having to restart it.
-func readFromTwitter(votes chan<- string) {
-	options, err := loadOptions()
+func (s *twitterSource) read(votes chan<- vote.Vote) {
+	polls, err := s.tracker.loadPolls()
 	if err != nil {
-		log.Println("failed to load options:", err)
+		log.Println("failed to load polls:", err)
 		return
 	}
 	u, err := url.Parse("https://stream.twitter.com/1.1/statuses/filter.json")
@@ -65,52 +169,50 @@ func readFromTwitter(votes chan<- string) {
 		return
 	}
 	query := make(url.Values)
-	query.Set("track", strings.Join(options, ","))
+	query.Set("track", strings.Join(trackedOptions(polls), ","))
 	req, err := http.NewRequest("POST", u.String(), strings.NewReader(query.Encode()))
 	if err != nil {
 		log.Println("creating filter request failed:", err)
 		return
 	}
-	resp, err := makeRequest(req, query)
+	resp, err := s.makeRequest(req, query)
 	if err != nil {
 		log.Println("making request failed:", err)
 		return
 	}
-	reader := resp.Body
-	decoder := json.NewDecoder(reader)
+	s.lock.Lock()
+	s.reader = resp.Body
+	s.lock.Unlock()
+	decoder := json.NewDecoder(resp.Body)
 	for {
 		var t tweet
 		if err := decoder.Decode(&t); err != nil {
 			break
 		}
-		for _, option := range options {
-			if strings.Contains(
-				strings.ToLower(t.Text),
-				strings.ToLower(option),
-			) {
-				log.Println("vote:", option)
-				votes <- option
-			}
-		}
+		s.tracker.sendMatches("twitter", t, polls, votes)
 	}
 }
 
 // dial ensures that the connection is closed and
 // then opens a new connection, keeping the conn
-// variable updated with the new connection.
+// field updated with the new connection.
 // If a connection dies or is closed, we can
 // redial without worrying about zombie connections.
-func dial(netw, addr string) (net.Conn, error) {
-	if conn != nil {
-		conn.Close()
-		conn = nil
+func (s *twitterSource) dial(netw, addr string) (net.Conn, error) {
+	s.lock.Lock()
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
 	}
+	s.lock.Unlock()
 	netc, err := net.DialTimeout(netw, addr, 5*time.Second)
 	if err != nil {
 		return nil, err
 	}
 
-	conn = netc
+	s.lock.Lock()
+	s.conn = netc
+	s.lock.Unlock()
 
 	return netc, nil
 }
@@ -119,54 +221,24 @@ func dial(netw, addr string) (net.Conn, error) {
 // the ongoing connection with Twitter and tidy things up.
 // If the program is called with Ctrl+C then we can call
 // this function just before exiting.
-func closeConn() {
-	if conn != nil {
-		conn.Close()
-	}
-	if reader != nil {
-		reader.Close()
-	}
-}
-
-// setupTwitterAuth reads the environment variables and
-// sets up the OAuth object needed in order to
-// authenticate requests.
-func setupTwitterAuth() {
-	var ts struct {
-		ConsumerKey    string `env:"SP_TWITTER_KEY,required"`
-		ConsumerSecret string `env:"SP_TWITTER_SECRET,required"`
-		AccessToken    string `env:"SP_TWITTER_ACCESSTOKEN,required"`
-		AccessSecret   string `env:"SP_TWITTER_ACCESSSECRET,required"`
-	}
-	if err := envdecode.Decode(&ts); err != nil {
-		log.Fatalln(err)
-	}
-	creds = &oauth.Credentials{
-		Token:  ts.AccessToken,
-		Secret: ts.AccessSecret,
+func (s *twitterSource) closeConn() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
 	}
-	authClient = &oauth.Client{
-		Credentials: oauth.Credentials{
-			Token:  ts.ConsumerKey,
-			Secret: ts.ConsumerSecret,
-		},
+	if s.reader != nil {
+		s.reader.Close()
 	}
 }
 
-// makeRequest makes sure the initialisation code runs only once.
-func makeRequest(req *http.Request, params url.Values) (*http.Response, error) {
-	authSetupOnce.Do(func() {
-		setupTwitterAuth()
-		httpClient = &http.Client{
-			Transport: &http.Transport{
-				Dial: dial,
-			},
-		}
-	})
+// makeRequest signs the request with the OAuth credentials
+// and sends it.
+func (s *twitterSource) makeRequest(req *http.Request, params url.Values) (*http.Response, error) {
 	formEnc := params.Encode()
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Content-Length", strconv.Itoa(len(formEnc)))
-	req.Header.Set("Authorization", authClient.AuthorizationHeader(creds, "POST", req.URL, params))
+	req.Header.Set("Authorization", s.authClient.AuthorizationHeader(s.creds, "POST", req.URL, params))
 
-	return httpClient.Do(req)
+	return s.httpClient.Do(req)
 }
@@ -0,0 +1,118 @@
+// Package twittervotes reads messages mentioning poll options
+// from vote sources, such as Twitter, and publishes the votes
+// they carry on the bus.
+package twittervotes
+
+import (
+	"log"
+	"socialpoll/bus"
+	"socialpoll/dedup"
+	"socialpoll/match"
+	"socialpoll/poll"
+	"socialpoll/vote"
+	"sync"
+)
+
+// Tracker decides which votes the messages read by
+// the sources carry.
+type Tracker struct {
+	// Polls holds the polls being voted on.
+	Polls poll.Store
+	// Voters records who voted for what, for polls
+	// giving each user a single vote.
+	Voters dedup.Store
+}
+
+// trackedPoll is a poll whose options are being looked for.
+type trackedPoll struct {
+	*poll.Poll
+	matchers map[string]match.Matcher
+}
+
+// loadPolls loads the options of every poll, and how
+// they are matched, from the database.
+func (tr *Tracker) loadPolls() ([]trackedPoll, error) {
+	active, err := tr.Polls.ActiveOptions()
+	if err != nil {
+		return nil, err
+	}
+	tracked := make([]trackedPoll, len(active))
+	for i, p := range active {
+		tracked[i] = trackedPoll{Poll: p, matchers: p.Matchers()}
+	}
+	return tracked, nil
+}
+
+// trackedOptions returns the options of all polls,
+// without duplicates.
+func trackedOptions(polls []trackedPoll) []string {
+	var options []string
+	seen := make(map[string]bool)
+	for _, p := range polls {
+		for _, option := range p.Options {
+			if !seen[option] {
+				seen[option] = true
+				options = append(options, option)
+			}
+		}
+	}
+	return options
+}
+
+func publishVotes(votes <-chan vote.Vote, pub bus.Publisher, topic string) <-chan struct{} {
+	stopchan := make(chan struct{}, 1)
+
+	go func() {
+		for v := range votes {
+			b, err := v.Encode()
+			if err != nil {
+				log.Println("failed to encode vote:", err)
+				continue
+			}
+			if err := pub.Publish(topic, b); err != nil {
+				log.Println("failed to publish vote:", err)
+			}
+		}
+		log.Println("Publisher: Stopping")
+		pub.Stop()
+		log.Println("Publisher: Stopped")
+		stopchan <- struct{}{}
+	}()
+
+	return stopchan
+}
+
+// Run reads votes from the sources selected by opts and publishes
+// them on the topic, until stop is closed or every source has
+// stopped. The publisher is stopped on return.
+func Run(opts Options, tr *Tracker, pub bus.Publisher, topic string, stop <-chan struct{}) error {
+	sources, err := newSources(&opts, tr)
+	if err != nil {
+		pub.Stop()
+		return err
+	}
+
+	go func() {
+		<-stop
+		log.Println("Stopping...")
+		for _, s := range sources {
+			s.Stop()
+		}
+	}()
+
+	// start the system
+	votes := make(chan vote.Vote)
+	publisherStoppedChan := publishVotes(votes, pub, topic)
+	var sourcesStopped sync.WaitGroup
+	for _, s := range sources {
+		sourcesStopped.Add(1)
+		go func(stoppedChan <-chan struct{}) {
+			<-stoppedChan
+			sourcesStopped.Done()
+		}(s.Start(votes))
+	}
+	sourcesStopped.Wait()
+	close(votes)
+	<-publisherStoppedChan
+	return nil
+}
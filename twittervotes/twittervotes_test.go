@@ -0,0 +1,195 @@
+package twittervotes
+
+import (
+	"reflect"
+	"socialpoll/dedup"
+	"socialpoll/match"
+	"socialpoll/poll"
+	"socialpoll/vote"
+	"sort"
+	"testing"
+	"time"
+)
+
+func newTestTracker() *Tracker {
+	return &Tracker{Polls: poll.NewMemoryStore(), Voters: dedup.NewMemoryStore()}
+}
+
+func createPoll(t *testing.T, tr *Tracker, p *poll.Poll) string {
+	if p.Status == "" {
+		p.Status = poll.Open
+	}
+	if err := tr.Polls.Create(p); err != nil {
+		t.Fatal(err)
+	}
+	return p.ID.Hex()
+}
+
+func TestLoadPolls(t *testing.T) {
+	tr := newTestTracker()
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	polls := map[string]*poll.Poll{
+		"open":        {Options: []string{"vim", "emacs"}},
+		"draft":       {Options: []string{"vim"}, Status: poll.Draft},
+		"closed":      {Options: []string{"vim"}, Status: poll.Closed},
+		"ended":       {Options: []string{"vim"}, ClosesAt: &past},
+		"not started": {Options: []string{"vim"}, OpensAt: &future},
+		"started":     {Options: []string{"nano"}, OpensAt: &past, ClosesAt: &future},
+		// stored before rules were validated
+		"bad rule": {Options: []string{"c++"}, Match: map[string]match.Rule{"c++": {Mode: match.Regex}}},
+	}
+	ids := make(map[string]string)
+	for name, p := range polls {
+		p.Title = name
+		ids[createPoll(t, tr, p)] = name
+	}
+	tracked, err := tr.loadPolls()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range tracked {
+		names = append(names, ids[p.ID.Hex()])
+		for _, option := range p.Options {
+			if p.matchers[option] == nil {
+				t.Errorf("%s: no matcher for option %q", ids[p.ID.Hex()], option)
+			}
+		}
+	}
+	sort.Strings(names)
+	if want := []string{"bad rule", "open", "started"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected polls %v to be tracked, got %v", want, names)
+	}
+	for _, p := range tracked {
+		if ids[p.ID.Hex()] == "bad rule" && !p.matchers["c++"].Match("I code in C++ daily") {
+			t.Errorf("expected a bad rule to fall back to matching the word")
+		}
+	}
+	options := trackedOptions(tracked)
+	sort.Strings(options)
+	if want := []string{"c++", "emacs", "nano", "vim"}; !reflect.DeepEqual(options, want) {
+		t.Errorf("expected options %v, got %v", want, options)
+	}
+}
+
+// matchVotes returns the votes sendMatches sends for the tweets.
+func matchVotes(tr *Tracker, tweets ...tweet) []vote.Vote {
+	polls, err := tr.loadPolls()
+	if err != nil {
+		panic(err)
+	}
+	votes := make(chan vote.Vote, 100)
+	for _, t := range tweets {
+		tr.sendMatches("test", t, polls, votes)
+	}
+	close(votes)
+	var result []vote.Vote
+	for v := range votes {
+		result = append(result, v)
+	}
+	return result
+}
+
+func newTweet(id, user, text string) tweet {
+	t := tweet{ID: id, Text: text, CreatedAt: "Mon Jan 02 15:04:05 +0000 2006"}
+	t.User.ID = user
+	return t
+}
+
+// voteString describes a vote as option, prefixed with - if it
+// is retracted.
+func voteString(v vote.Vote) string {
+	if v.Retract {
+		return "-" + v.Option
+	}
+	return v.Option
+}
+
+func TestSendMatches(t *testing.T) {
+	retweet := newTweet("4", "u1", "RT vim")
+	retweet.RetweetedStatus = &tweet{Text: "vim"}
+	reply := newTweet("5", "u1", "@u2 vim")
+	reply.InReplyToStatusID = "1"
+	quote := newTweet("6", "u1", "so true")
+	quote.QuotedStatus = &tweet{Text: "emacs rules"}
+
+	tests := []struct {
+		name   string
+		poll   poll.Poll
+		tweets []tweet
+		votes  []string
+	}{
+		{"words", poll.Poll{Options: []string{"vim", "emacs"}},
+			[]tweet{newTweet("1", "u1", "VIM forever"), newTweet("2", "u2", "vimscript"), newTweet("3", "u3", "nothing")},
+			[]string{"vim"}},
+		{"every option mentioned counts", poll.Poll{Options: []string{"vim", "emacs"}},
+			[]tweet{newTweet("1", "u1", "vim and emacs")},
+			[]string{"vim", "emacs"}},
+		{"hashtags", poll.Poll{Options: []string{"go", "rust"},
+			Match: map[string]match.Rule{"go": {Mode: match.Hashtag}, "rust": {Mode: match.Hashtag}}},
+			[]tweet{newTweet("1", "u1", "let's go"), newTweet("2", "u2", "#Rust")},
+			[]string{"rust"}},
+		{"retweets excluded", poll.Poll{Options: []string{"vim"}, Filter: poll.TweetFilter{ExcludeRetweets: true}},
+			[]tweet{retweet, newTweet("1", "u1", "vim")},
+			[]string{"vim"}},
+		{"replies excluded", poll.Poll{Options: []string{"vim"}, Filter: poll.TweetFilter{ExcludeReplies: true}},
+			[]tweet{reply},
+			nil},
+		{"quoted text", poll.Poll{Options: []string{"vim", "emacs"}},
+			[]tweet{quote},
+			[]string{"emacs"}},
+		{"quoting text only", poll.Poll{Options: []string{"vim", "emacs"}, Filter: poll.TweetFilter{QuotingTextOnly: true}},
+			[]tweet{quote},
+			nil},
+		{"first vote counts", poll.Poll{Options: []string{"vim", "emacs"}, Dedup: dedup.First},
+			[]tweet{newTweet("1", "u1", "vim"), newTweet("2", "u1", "emacs"), newTweet("3", "u2", "emacs")},
+			[]string{"vim", "emacs"}},
+		{"last vote counts", poll.Poll{Options: []string{"vim", "emacs"}, Dedup: dedup.Last},
+			[]tweet{newTweet("1", "u1", "vim"), newTweet("2", "u1", "vim"), newTweet("3", "u1", "emacs")},
+			[]string{"vim", "-vim", "emacs"}},
+		{"ambiguous messages ignored", poll.Poll{Options: []string{"vim", "emacs"}, Dedup: dedup.First},
+			[]tweet{newTweet("1", "u1", "vim or emacs"), newTweet("2", "u1", "emacs")},
+			[]string{"emacs"}},
+		{"anonymous messages ignored", poll.Poll{Options: []string{"vim"}, Dedup: dedup.Last},
+			[]tweet{newTweet("1", "", "vim")},
+			nil},
+		{"unknown policy", poll.Poll{Options: []string{"vim"}, Dedup: "sometimes"},
+			[]tweet{newTweet("1", "u1", "vim")},
+			nil},
+	}
+	for _, test := range tests {
+		tr := newTestTracker()
+		p := test.poll
+		id := createPoll(t, tr, &p)
+		var got []string
+		for _, v := range matchVotes(tr, test.tweets...) {
+			if v.PollID != id || v.Source != "test" || v.SourceID == "" || v.Time.Year() != 2006 {
+				t.Errorf("%s: unexpected vote %+v", test.name, v)
+			}
+			got = append(got, voteString(v))
+		}
+		if !reflect.DeepEqual(got, test.votes) {
+			t.Errorf("%s: expected votes %v, got %v", test.name, test.votes, got)
+		}
+	}
+}
+
+func TestSendMatchesPerPoll(t *testing.T) {
+	tr := newTestTracker()
+	editors := createPoll(t, tr, &poll.Poll{Options: []string{"vim", "emacs"}, Dedup: dedup.First})
+	all := createPoll(t, tr, &poll.Poll{Options: []string{"vim", "nano"}})
+	createPoll(t, tr, &poll.Poll{Options: []string{"vim"}, Status: poll.Closed})
+
+	votes := matchVotes(tr, newTweet("1", "u1", "vim"), newTweet("2", "u1", "vim and nano"))
+	got := make(map[string][]string)
+	for _, v := range votes {
+		got[v.PollID] = append(got[v.PollID], voteString(v))
+	}
+	want := map[string][]string{
+		editors: {"vim"},
+		all:     {"vim", "vim", "nano"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected votes %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,48 @@
+// Package vote defines the messages twittervotes publishes
+// on the "votes" topic and counter consumes.
+package vote
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Vote is a single vote for an option of a poll.
+type Vote struct {
+	// PollID is the hex ID of the poll the vote was matched for.
+	PollID string `json:"poll"`
+	// Option is the poll option voted for.
+	Option string `json:"option"`
+	// Source names the vote source the vote was read from,
+	// such as "twitter".
+	Source string `json:"source"`
+	// SourceID identifies the message the vote was read from
+	// within its source, such as a tweet ID.
+	SourceID string `json:"sourceId,omitempty"`
+	// Time is when the vote was cast.
+	Time time.Time `json:"time"`
+	// Retract is set when the vote takes back an earlier vote
+	// for the option, because the voter changed their mind.
+	Retract bool `json:"retract,omitempty"`
+}
+
+// Encode returns the wire representation of the vote.
+func (v *Vote) Encode() ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Decode reads a vote from its wire representation.
+func Decode(b []byte) (*Vote, error) {
+	var v Vote
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	if v.PollID == "" {
+		return nil, errors.New("vote: missing poll")
+	}
+	if v.Option == "" {
+		return nil, errors.New("vote: missing option")
+	}
+	return &v, nil
+}
@@ -4,13 +4,19 @@ import (
 	"flag"
 	"log"
 	"net/http"
+	"socialpoll/config"
 )
 
 func main() {
-	var addr = flag.String("addr", ":8081", "website address")
+	conf := config.Register(flag.CommandLine)
+	conf.Alias(flag.CommandLine, "addr", "web.addr")
 	flag.Parse()
+	cfg, err := conf.Load()
+	if err != nil {
+		log.Fatalln("failed to load config:", err)
+	}
 	mux := http.NewServeMux()
 	mux.Handle("/", http.StripPrefix("/", http.FileServer(http.Dir("public"))))
-	log.Println("Serving website at:", *addr)
-	http.ListenAndServe(*addr, mux)
+	log.Println("Serving website at:", cfg.Web.Addr)
+	http.ListenAndServe(cfg.Web.Addr, mux)
 }
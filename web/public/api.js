@@ -0,0 +1,27 @@
+// api.js builds the URLs of API calls. The API key is asked
+// for once and remembered by the browser, rather than being
+// written into the pages.
+var apiRoot = "http://localhost:8080/v1/";
+
+var apiKey = function(){
+  var key = localStorage.getItem("apikey");
+  if (!key) {
+    key = prompt("API key");
+    if (key) {
+      localStorage.setItem("apikey", key);
+    }
+  }
+  return key;
+};
+
+var apiURL = function(path){
+  var sep = path.indexOf("?") < 0 ? "?" : "&";
+  return apiRoot + path + sep + "key=" + encodeURIComponent(apiKey() || "");
+};
+
+// forget a rejected key, so that the next call asks again
+$(document).ajaxError(function(e, r){
+  if (r.status == 401) {
+    localStorage.removeItem("apikey");
+  }
+});